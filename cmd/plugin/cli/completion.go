@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/logger"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"github.com/spf13/cobra"
+	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// completions query the cluster, but shouldn't hang the shell if it is unreachable
+const completionTimeout = 5 * time.Second
+
+func completionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish",
+		Short: "Print the shell completion script",
+		Long: `Prints the completion script for bash, zsh or fish.
+
+Namespaces, contexts, resource types and CRD groups are completed from the
+current cluster and kubeconfig.`,
+		Example: `  # bash, for the current shell
+  source <(kubectl-purge completion bash)
+
+  # zsh, installed for every shell
+  kubectl-purge completion zsh > "${fpath[1]}/_kubectl-purge"
+
+  # fish
+  kubectl-purge completion fish > ~/.config/fish/completions/kubectl-purge.fish`,
+		ValidArgs:             []string{"bash", "zsh", "fish"},
+		Args:                  cobra.ExactValidArgs(1),
+		DisableFlagsInUseLine: true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return cmd.Root().GenBashCompletion(os.Stdout)
+			case "zsh":
+				return cmd.Root().GenZshCompletion(os.Stdout)
+			case "fish":
+				return cmd.Root().GenFishCompletion(os.Stdout, true)
+			}
+			return errors.New("unsupported shell " + args[0])
+		},
+	}
+}
+
+// registerCompletions adds the dynamic completions of the flags shared by every command
+func registerCompletions(cmd *cobra.Command) {
+	completions := map[string]func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective){
+		"context":    completeContexts,
+		"namespace":  completeNamespaces,
+		"only":       completeKinds,
+		"skip":       completeKinds,
+		"profile":    completeValues(plugin.ProfileNames()...),
+		"strategy":   completeValues(plugin.StrategyNames()...),
+		"log-format": completeValues(logger.FormatText, logger.FormatJSON),
+	}
+	for flag, completion := range completions {
+		// only fails if the flag doesn't exist
+		_ = cmd.RegisterFlagCompletionFunc(flag, completion)
+	}
+}
+
+func completeValues(values ...string) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filterCompletions(values, nil, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := KubernetesConfigFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	return filterCompletions(contexts, nil, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeNamespaces completes namespace names, leaving out the ones already given as arguments
+func completeNamespaces(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	clientset, err := completionClientset()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	names := make([]string, 0, len(namespaces.Items))
+	for _, namespace := range namespaces.Items {
+		names = append(names, namespace.Name)
+	}
+	return filterCompletions(names, args, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeKinds completes the resource types of --only and --skip, which take comma separated lists
+func completeKinds(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	discoveryClient, err := KubernetesConfigFlags.ToDiscoveryClient()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	resourceLists, err := discoveryClient.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	kinds := []string{"all"}
+	for _, resourceList := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, resourceLists) {
+		groupVersion, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range resourceList.APIResources {
+			kinds = append(kinds, resource.Name)
+			kinds = append(kinds, resource.ShortNames...)
+			if groupVersion.Group != "" {
+				kinds = append(kinds, resource.Name+"."+groupVersion.Group)
+			}
+		}
+	}
+
+	// complete the last element of the list, keeping the ones before it
+	given := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		given, toComplete = toComplete[:i+1], toComplete[i+1:]
+	}
+	completions := filterCompletions(kinds, strings.Split(given, ","), toComplete)
+	for i := range completions {
+		completions[i] = given + completions[i]
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completeCrdGroups completes the API groups of the CRDs on the cluster
+func completeCrdGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := KubernetesConfigFlags.ToRESTConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	config.Timeout = completionTimeout
+	apixClient, err := apixv1client.NewForConfig(config)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	crds, err := apixClient.CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	groups := make([]string, 0, len(crds.Items))
+	for _, crd := range crds.Items {
+		groups = append(groups, crd.Spec.Group)
+	}
+	return filterCompletions(groups, args, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completionClientset() (*kubernetes.Clientset, error) {
+	config, err := KubernetesConfigFlags.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = completionTimeout
+	return kubernetes.NewForConfig(config)
+}
+
+// filterCompletions returns the sorted, unique values starting with toComplete, except the ones in given
+func filterCompletions(values []string, given []string, toComplete string) []string {
+	excluded := map[string]bool{}
+	for _, value := range given {
+		excluded[value] = true
+	}
+
+	var completions []string
+	for _, value := range values {
+		if excluded[value] || !strings.HasPrefix(value, toComplete) {
+			continue
+		}
+		excluded[value] = true
+		completions = append(completions, value)
+	}
+	sort.Strings(completions)
+	return completions
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+const manifestsLong = `The ClusterRole only grants get, list and delete on the kinds the purge deletes,
+including the custom resources of the CRDs currently on the cluster.
+
+Every generated object is labeled purge.kubectl.io/protected=true, so purges skip them.
+
+Arguments after -- are passed on to the container, e.g. -- --older-than=24h.
+The ClusterRole is derived from them, so purge flags such as --only or --gentle go after --.`
+
+func renderManifestsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "render-manifests [-- purge flags]",
+		Short:         "Print the manifests to run kubectl-purge in-cluster with least-privilege RBAC",
+		Long:          manifestsLong,
+		Example:       "  kubectl purge render-manifests --image=example.com/kubectl-purge:v0.1.0 -- --older-than=24h > purge.yaml",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := containerOptions(cmd, args)
+			if err != nil {
+				return err
+			}
+			if err := plugin.RenderManifests(KubernetesConfigFlags, opts, manifestOptions(args), os.Stdout); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	addManifestFlags(cmd)
+	return cmd
+}
+
+func installCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "install [-- purge flags]",
+		Short:         "Install kubectl-purge in-cluster with least-privilege RBAC",
+		Long:          manifestsLong,
+		Example:       "  kubectl purge install --image=example.com/kubectl-purge:v0.1.0 --mode=cronjob --schedule='0 3 * * *'",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := newLogger()
+			opts, err := containerOptions(cmd, args)
+			if err != nil {
+				return err
+			}
+
+			logCh, errorCh, logWaitGroup := printLogs(log)
+			err = plugin.InstallManifests(KubernetesConfigFlags, opts, manifestOptions(args), logCh)
+			close(logCh)
+			close(errorCh)
+			logWaitGroup.Wait()
+			if err != nil {
+				return err
+			}
+
+			log.Info("Installed")
+			return nil
+		},
+	}
+	addManifestFlags(cmd)
+	return cmd
+}
+
+func addManifestFlags(cmd *cobra.Command) {
+	cmd.Flags().String("install-namespace", "kubectl-purge", "Namespace to run kubectl-purge in")
+	cmd.Flags().String("image", "", "Container image of kubectl-purge")
+	cmd.Flags().String("mode", plugin.ManifestModeJanitor, "Run the janitor as a Deployment (janitor), or a full purge as a CronJob (cronjob)")
+	cmd.Flags().String("schedule", "@daily", "Schedule of the CronJob")
+}
+
+// containerOptions parses the purge flags in args, which the container runs with, so the ClusterRole fits them.
+// Purge flags given to the command itself would be ignored by the container, so they are refused.
+func containerOptions(cmd *cobra.Command, args []string) (plugin.Options, error) {
+	flags := pflag.NewFlagSet(cmd.Name(), pflag.ContinueOnError)
+	addPurgeFlags(flags)
+
+	var misplaced []string
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if flags.Lookup(flag.Name) != nil {
+			misplaced = append(misplaced, "--"+flag.Name)
+		}
+	})
+	if len(misplaced) > 0 {
+		return plugin.Options{}, errors.New(fmt.Sprintf("purge flags configure the container, pass them after --: %s", strings.Join(misplaced, ", ")))
+	}
+
+	// the flags of the janitor and cluster commands are for the container alone
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	if err := flags.Parse(args); err != nil {
+		return plugin.Options{}, errors.Wrap(err, "failed to parse the arguments after --")
+	}
+	v := viper.New()
+	if err := v.BindPFlags(flags); err != nil {
+		return plugin.Options{}, errors.Wrap(err, "failed to bind flags")
+	}
+	return optionsFrom(v), nil
+}
+
+func manifestOptions(args []string) plugin.ManifestOptions {
+	return plugin.ManifestOptions{
+		Namespace: viper.GetString("install-namespace"),
+		Image:     viper.GetString("image"),
+		Mode:      viper.GetString("mode"),
+		Schedule:  viper.GetString("schedule"),
+		Args:      args,
+	}
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func janitorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "janitor",
+		Short: "Continuously purge namespaces whose TTL has expired",
+		Long: `Watches namespaces and purges every namespace whose purge.kubectl.io/ttl
+(a duration since creation) or purge.kubectl.io/expires-at (an RFC 3339 timestamp)
+annotation has passed.
+
+Works with the in-cluster config when no kubeconfig is found, so it can run as a
+Deployment. Only the replica holding the leader election Lease purges.`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := newLogger()
+
+			janitorOpts, err := janitorOptions()
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			logCh, errorCh, logWaitGroup := printLogs(log)
+
+			log.Info("Running janitor")
+			err = plugin.RunJanitor(ctx, KubernetesConfigFlags, pluginOptions(), janitorOpts, logCh, errorCh)
+			logWaitGroup.Wait()
+			if err != nil {
+				return err
+			}
+			log.Info("Finished")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Duration("interval", time.Minute, "How often namespaces are checked for an expired TTL")
+	cmd.Flags().String("lease-namespace", "", "Namespace of the leader election Lease, defaults to the current namespace")
+	cmd.Flags().String("lease-name", "kubectl-purge-janitor", "Name of the leader election Lease")
+	cmd.Flags().String("identity", "", "Unique name of this replica, defaults to the hostname")
+
+	return cmd
+}
+
+func janitorOptions() (plugin.JanitorOptions, error) {
+	leaseNamespace := viper.GetString("lease-namespace")
+	if leaseNamespace == "" {
+		namespace, _, err := KubernetesConfigFlags.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return plugin.JanitorOptions{}, errors.Wrap(err, "failed to read the current namespace")
+		}
+		leaseNamespace = namespace
+	}
+
+	identity := viper.GetString("identity")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return plugin.JanitorOptions{}, errors.Wrap(err, "failed to read hostname")
+		}
+		identity = hostname
+	}
+
+	return plugin.JanitorOptions{
+		Interval:       viper.GetDuration("interval"),
+		LeaseNamespace: leaseNamespace,
+		LeaseName:      viper.GetString("lease-name"),
+		Identity:       identity,
+	}, nil
+}
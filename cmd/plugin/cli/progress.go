@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/robertsmieja/kubectl-purge/pkg/logger"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the progress view is redrawn on a terminal
+	redrawInterval = 200 * time.Millisecond
+	// how often a progress line is printed when stdout isn't a terminal
+	progressLineInterval = 10 * time.Second
+	// at most this many namespaces are shown in the progress view
+	maxProgressNamespaces = 15
+	// at most this many errors are kept for the report
+	maxReportedFailures = 100
+)
+
+type kindProgress struct {
+	found   int
+	deleted int
+	failed  int
+}
+
+func (k *kindProgress) done() bool {
+	return k.deleted+k.failed >= k.found
+}
+
+type namespaceProgress struct {
+	kinds map[string]*kindProgress
+	// kinds in the order they were first reported
+	order []string
+	// when the first event of the namespace was reported
+	start time.Time
+}
+
+func (n *namespaceProgress) totals() kindProgress {
+	total := kindProgress{}
+	for _, kind := range n.kinds {
+		total.found += kind.found
+		total.deleted += kind.deleted
+		total.failed += kind.failed
+	}
+	return total
+}
+
+// done reports whether the namespace itself was deleted, or failed to be
+func (n *namespaceProgress) done() bool {
+	kind, ok := n.kinds[plugin.NamespaceProgressKind]
+	return ok && kind.found > 0 && kind.done()
+}
+
+// progressPrinter prints messages, errors and the progress of a purge.
+// On a terminal the progress view is redrawn below the messages, otherwise a progress line is printed periodically.
+type progressPrinter struct {
+	log        *logger.Logger
+	out        io.Writer
+	terminal   bool
+	start      time.Time
+	namespaces map[string]*namespaceProgress
+	drawnLines int
+	// the errors printed, for the report
+	failures []string
+	// how many errors didn't fit into failures
+	moreFailures int
+}
+
+// printProgress prints everything sent on the returned channels, until all of them are closed.
+// The printer can be read once the WaitGroup is done.
+func printProgress(log *logger.Logger) (*progressPrinter, chan string, chan error, chan plugin.ProgressEvent, *sync.WaitGroup) {
+	logCh := make(chan string, 1)
+	errorCh := make(chan error, 1)
+	progressCh := make(chan plugin.ProgressEvent, 100)
+
+	printer := &progressPrinter{
+		log:        log,
+		out:        os.Stdout,
+		terminal:   term.IsTerminal(int(os.Stdout.Fd())) && !log.JSON(),
+		start:      time.Now(),
+		namespaces: map[string]*namespaceProgress{},
+	}
+
+	waitGroup := &sync.WaitGroup{}
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		printer.run(logCh, errorCh, progressCh)
+	}()
+	return printer, logCh, errorCh, progressCh, waitGroup
+}
+
+func (p *progressPrinter) run(logCh <-chan string, errorCh <-chan error, progressCh <-chan plugin.ProgressEvent) {
+	interval := progressLineInterval
+	if p.terminal {
+		interval = redrawInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for logCh != nil || errorCh != nil || progressCh != nil {
+		select {
+		case msg, ok := <-logCh:
+			if !ok {
+				logCh = nil
+				continue
+			}
+			level, text := plugin.MessageLevel(msg)
+			if p.log.V(level) == nil {
+				continue
+			}
+			p.clear()
+			p.log.Info(text)
+		case err, ok := <-errorCh:
+			if !ok {
+				errorCh = nil
+				continue
+			}
+			p.clear()
+			p.log.Error(err)
+			p.addFailure(err)
+		case event, ok := <-progressCh:
+			if !ok {
+				progressCh = nil
+				continue
+			}
+			p.add(event)
+		case <-ticker.C:
+			if p.terminal {
+				p.clear()
+				p.draw()
+			} else {
+				p.log.Info(p.summary())
+			}
+		}
+	}
+
+	p.clear()
+	p.log.Info(p.summary())
+}
+
+func (p *progressPrinter) add(event plugin.ProgressEvent) {
+	namespace, ok := p.namespaces[event.Namespace]
+	if !ok {
+		namespace = &namespaceProgress{kinds: map[string]*kindProgress{}, start: time.Now()}
+		p.namespaces[event.Namespace] = namespace
+	}
+
+	kind, ok := namespace.kinds[event.Kind]
+	if !ok {
+		kind = &kindProgress{}
+		namespace.kinds[event.Kind] = kind
+		namespace.order = append(namespace.order, event.Kind)
+	}
+
+	kind.found += event.Found
+	kind.deleted += event.Deleted
+	kind.failed += event.Failed
+}
+
+func (p *progressPrinter) addFailure(err error) {
+	if len(p.failures) == maxReportedFailures {
+		p.moreFailures++
+		return
+	}
+	p.failures = append(p.failures, err.Error())
+}
+
+// report adds the counts and errors to report
+func (p *progressPrinter) report(report *plugin.Report) {
+	counts := &plugin.ReportCounts{Kinds: map[string]plugin.KindCount{}}
+	for _, namespace := range p.namespaces {
+		for name, kind := range namespace.kinds {
+			count := counts.Kinds[name]
+			count.Found += kind.found
+			count.Deleted += kind.deleted
+			count.Failed += kind.failed
+			counts.Kinds[name] = count
+
+			counts.Found += kind.found
+			counts.Deleted += kind.deleted
+			counts.Failed += kind.failed
+		}
+	}
+	report.Counts = counts
+
+	report.Failures = p.failures
+	if p.moreFailures > 0 {
+		report.Failures = append(report.Failures, fmt.Sprintf("... and %d more errors", p.moreFailures))
+	}
+}
+
+// summary is a single line with the overall progress, elapsed time and ETA
+func (p *progressPrinter) summary() string {
+	total := kindProgress{}
+	for _, namespace := range p.namespaces {
+		totals := namespace.totals()
+		total.found += totals.found
+		total.deleted += totals.deleted
+		total.failed += totals.failed
+	}
+
+	return fmt.Sprintf("Progress: %d/%d deleted, %d failed, %s", total.deleted, total.found, total.failed, timing(p.start, total))
+}
+
+// timing is the time elapsed since start, and the ETA if some but not all objects were processed
+func timing(start time.Time, progress kindProgress) string {
+	elapsed := time.Since(start)
+	timing := fmt.Sprintf("elapsed %s", elapsed.Round(time.Second))
+
+	processed := progress.deleted + progress.failed
+	if processed > 0 && processed < progress.found {
+		eta := time.Duration(float64(elapsed) / float64(processed) * float64(progress.found-processed))
+		timing += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return timing
+}
+
+// clear erases the progress view, so that a message can be printed in its place
+func (p *progressPrinter) clear() {
+	if p.drawnLines > 0 {
+		fmt.Fprintf(p.out, "\033[%dA\033[J", p.drawnLines)
+		p.drawnLines = 0
+	}
+}
+
+// draw prints the progress view, a line per unfinished namespace with done/total per kind
+func (p *progressPrinter) draw() {
+	width := 0
+	if columns, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width = columns - 1
+	}
+
+	names := make([]string, 0, len(p.namespaces))
+	finished := 0
+	for name, namespace := range p.namespaces {
+		if namespace.done() {
+			finished++
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{p.summary()}
+	if finished > 0 {
+		lines = append(lines, fmt.Sprintf("  %d namespaces deleted", finished))
+	}
+	for i, name := range names {
+		if i == maxProgressNamespaces {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(names)-maxProgressNamespaces))
+			break
+		}
+		lines = append(lines, p.namespaceLine(name))
+	}
+
+	for _, line := range lines {
+		if width > 0 && len(line) > width {
+			line = line[:width]
+		}
+		fmt.Fprintln(p.out, line)
+	}
+	p.drawnLines = len(lines)
+}
+
+func (p *progressPrinter) namespaceLine(name string) string {
+	namespace := p.namespaces[name]
+
+	label := name
+	if label == "" {
+		label = "(cluster)"
+	}
+
+	counters := make([]string, 0, len(namespace.order))
+	for _, kindName := range namespace.order {
+		kind := namespace.kinds[kindName]
+		if kind.found == 0 {
+			continue
+		}
+		counters = append(counters, fmt.Sprintf("%s %d/%d", kindName, kind.deleted, kind.found))
+	}
+
+	totals := namespace.totals()
+	line := fmt.Sprintf("  %s: %s", label, strings.Join(counters, ", "))
+	if totals.failed > 0 {
+		line += fmt.Sprintf(" (%d errors)", totals.failed)
+	}
+	return line + fmt.Sprintf(", %s", timing(namespace.start, totals))
+}
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"github.com/manifoldco/promptui"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"time"
+)
+
+const (
+	// how often a report that failed to be delivered is sent again
+	notifyRetries = 3
+	// how long delivering a report to one URL may take
+	notifyTimeout = 10 * time.Second
+)
+
+// purgeCmd completes a subcommand that purges, configure narrows the options down to what the subcommand purges
+func purgeCmd(cmd *cobra.Command, configure func(opts *plugin.Options, args []string)) *cobra.Command {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		err := viper.BindPFlags(cmd.Flags())
+		if err != nil {
+			return errors.Wrap(err, "failed to bind flags")
+		}
+		return confirm()
+	}
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		opts := pluginOptions()
+		configure(&opts, args)
+		return runPurge(opts)
+	}
+
+	cmd.Flags().BoolP("yes", "y", false, "Delete without confirming")
+	cmd.Flags().StringSlice("notify-url", []string{}, "POST a JSON report to this URL when the purge finished, can be repeated")
+	cmd.Flags().String("notify-secret", "", "Sign the reports with an HMAC-SHA256 of this secret in the X-Purge-Signature header, also read from NOTIFY_SECRET")
+	cmd.Flags().Bool("notify-start", false, "With --notify-url, also POST a report when the purge starts")
+	return cmd
+}
+
+// confirm asks before a destructive run, unless --yes was passed
+func confirm() error {
+	if viper.GetBool("yes") {
+		return nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "This is a destructive operation, are you sure",
+		IsConfirm: true,
+	}
+
+	result, err := prompt.Run()
+	if err != nil {
+		return errors.Wrap(err, "Prompt failed")
+	}
+
+	confirmation := result == "y" || result == "Y"
+	if !confirmation {
+		return errors.New("No confirmation was given!")
+	}
+	return nil
+}
+
+func runPurge(opts plugin.Options) error {
+	log := newLogger()
+	notifyOpts := plugin.NotifyOptions{
+		URLs:    viper.GetStringSlice("notify-url"),
+		Secret:  viper.GetString("notify-secret"),
+		Retries: notifyRetries,
+		Timeout: notifyTimeout,
+	}
+	start := time.Now()
+	if len(notifyOpts.URLs) > 0 && viper.GetBool("notify-start") {
+		report := plugin.NewReport(KubernetesConfigFlags, opts, plugin.ReportEventStarted, start)
+		if err := plugin.Notify(context.Background(), notifyOpts, report); err != nil {
+			log.Error(err)
+		}
+	}
+
+	printer, logCh, errorCh, progressCh, logWaitGroup := printProgress(log)
+	opts.Progress = progressCh
+
+	log.Info("Running")
+	runErr := plugin.RunPlugin(KubernetesConfigFlags, opts, logCh, errorCh)
+	close(progressCh)
+	logWaitGroup.Wait()
+
+	if len(notifyOpts.URLs) > 0 {
+		report := plugin.NewReport(KubernetesConfigFlags, opts, plugin.ReportEventFinished, start)
+		printer.report(&report)
+		finished := time.Now()
+		report.FinishedAt = &finished
+		report.Duration = finished.Sub(start).Seconds()
+		if runErr != nil {
+			report.Error = runErr.Error()
+		}
+		if err := plugin.Notify(context.Background(), notifyOpts, report); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	log.Info("Finished")
+	return nil
+}
+
+func clusterCmd() *cobra.Command {
+	return purgeCmd(&cobra.Command{
+		Use:   "cluster",
+		Short: "Purge every namespace and the cluster-scoped objects",
+		Long: `Purges the contents of every namespace and deletes the namespaces, then the
+cluster-scoped objects: ClusterRoles, ClusterRoleBindings, PodSecurityPolicies,
+IngressClasses, CRDs with their custom resources and PersistentVolumes.
+
+System namespaces, protected objects, control-plane objects and the objects of
+the detected distribution are kept.`,
+		Example: `  # purge everything
+  kubectl purge cluster
+
+  # purge everything older than a day, without confirming
+  kubectl purge cluster --older-than=24h --yes`,
+		Args: cobra.NoArgs,
+	}, func(opts *plugin.Options, args []string) {
+		opts.Scope = plugin.ScopeCluster
+	})
+}
+
+func namespaceCmd() *cobra.Command {
+	cmd := purgeCmd(&cobra.Command{
+		Use:   "namespace <names...>",
+		Short: "Purge the contents of namespaces",
+		Long: `Purges the contents of the given namespaces, including their custom resources.
+Cluster-scoped objects are kept.
+
+The namespaces themselves are kept, unless --delete-namespace is passed.`,
+		Example: `  # purge the contents of the dev namespace
+  kubectl purge namespace dev
+
+  # purge two namespaces and delete them
+  kubectl purge namespace dev staging --delete-namespace`,
+		Aliases:           []string{"namespaces", "ns"},
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeNamespaces,
+	}, func(opts *plugin.Options, args []string) {
+		opts.Scope = plugin.ScopeNamespaces
+		opts.Namespaces = args
+		opts.KeepNamespaces = !viper.GetBool("delete-namespace")
+	})
+	cmd.Flags().Bool("delete-namespace", false, "Delete the namespaces too, not only their contents")
+	return cmd
+}
+
+func crdsCmd() *cobra.Command {
+	return purgeCmd(&cobra.Command{
+		Use:   "crds [groups...]",
+		Short: "Purge CRDs and their custom resources",
+		Long: `Deletes the custom resources of every CRD, in every namespace, and then the CRDs.
+Only the CRDs of the given API groups are purged, if any are given.`,
+		Example: `  # purge every CRD
+  kubectl purge crds
+
+  # purge the CRDs of cert-manager
+  kubectl purge crds cert-manager.io acme.cert-manager.io`,
+		Aliases:           []string{"crd"},
+		ValidArgsFunction: completeCrdGroups,
+	}, func(opts *plugin.Options, args []string) {
+		opts.Scope = plugin.ScopeCrds
+		opts.CrdGroups = args
+	})
+}
+
+func planCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "plan",
+		Short: "Print what purging the cluster would delete, without deleting anything",
+		Long: `Runs a cluster purge with the same flags, including the guardrails, permission
+check and filters, but only prints the objects it would delete.`,
+		Example: `  # see what purging Deployments older than a day would delete
+  kubectl purge plan --only=deploy --older-than=24h`,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := newLogger()
+			logCh, errorCh, logWaitGroup := printLogs(log)
+
+			opts := pluginOptions()
+			opts.Scope = plugin.ScopeCluster
+			opts.DryRun = true
+
+			err := plugin.RunPlugin(KubernetesConfigFlags, opts, logCh, errorCh)
+			logWaitGroup.Wait()
+			return err
+		},
+	}
+}
+
+func verifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "List what a cluster purge left behind, and why",
+		Long: `Inventories every kind in the namespaces a cluster purge with the same flags
+would purge, and the cluster-scoped kinds it deletes. Objects kept on purpose,
+e.g. protected ones, are left out. Every other object is listed with the likely
+reason it remains: terminating with finalizers, an owner that still exists, or
+not deleted.
+
+Exits non-zero if anything unexpected remains. Pass --verify to a purge to
+verify right after it, which also tells recreated objects apart.`,
+		Example: `  # check what is left after a purge
+  kubectl purge verify
+
+  # wait up to 5 minutes for terminating objects to disappear
+  kubectl purge verify --verify-timeout=5m`,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := newLogger()
+			logCh, errorCh, logWaitGroup := printLogs(log)
+
+			opts := pluginOptions()
+			opts.Scope = plugin.ScopeCluster
+
+			err := plugin.VerifyPlugin(KubernetesConfigFlags, opts, logCh, errorCh)
+			logWaitGroup.Wait()
+			return err
+		},
+	}
+}
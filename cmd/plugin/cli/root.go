@@ -1,17 +1,21 @@
 package cli
 
 import (
+	"flag"
 	"fmt"
-	"github.com/manifoldco/promptui"
 	"github.com/pkg/errors"
 	"github.com/robertsmieja/kubectl-purge/pkg/logger"
 	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -20,89 +24,183 @@ var (
 
 func RootCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:           "kubectl-purge",
-		Short:         "",
-		Long:          `.`,
+		Use:   "kubectl-purge",
+		Short: "Delete everything that was deployed to a Kubernetes cluster",
+		Long: `Deletes the objects deployed to a Kubernetes cluster, leaving the cluster
+itself and what the control plane or the distribution needs intact.
+
+Start with "kubectl purge plan" to see what a purge would delete.`,
+		Example: `  # see what would be deleted
+  kubectl purge plan
+
+  # purge the whole cluster
+  kubectl purge cluster
+
+  # purge the contents of two namespaces
+  kubectl purge namespace dev staging`,
 		SilenceErrors: true,
 		SilenceUsage:  true,
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			err := viper.BindPFlags(cmd.Flags())
-			if err != nil {
-				return errors.Wrap(err, "failed to bind flags")
-			}
-
-			yes := viper.GetBool("yes")
-
-			if !yes {
-				prompt := promptui.Prompt{
-					Label:     "This is a destructive operation, are you sure",
-					IsConfirm: true,
-				}
-
-				result, err := prompt.Run()
-				if err != nil {
-					return errors.Wrap(err, "Prompt failed")
-				}
-
-				confirmation := result == "y" || result == "Y"
-				if !confirmation {
-					return errors.New("No confirmation was given!")
-				}
-			}
-			return nil
-		},
+		// purging the whole cluster used to be the default, it now has to be asked for
 		RunE: func(cmd *cobra.Command, args []string) error {
-			log := logger.NewLogger()
-
-			logCh := make(chan string, 1)
-			errorCh := make(chan error, 1)
-
-			// print messages and errors while running
-			logWaitGroup := sync.WaitGroup{}
-			logWaitGroup.Add(2)
-			logMutex := &sync.Mutex{}
-			go func() {
-				defer logWaitGroup.Done()
-				for logStr := range logCh {
-					logMutex.Lock()
-					log.Info(logStr)
-					logMutex.Unlock()
-				}
-			}()
-			go func() {
-				defer logWaitGroup.Done()
-				for err := range errorCh {
-					logMutex.Lock()
-					log.Error(err)
-					logMutex.Unlock()
-				}
-			}()
-
-			log.Info("Running")
-			if err := plugin.RunPlugin(KubernetesConfigFlags, logCh, errorCh); err != nil {
-				return errors.Cause(err)
-			}
-			logWaitGroup.Wait()
-			log.Info("Finished")
-
-			return nil
+			return errors.New(`a subcommand is required, e.g. "kubectl purge cluster" to purge the whole cluster, see "kubectl purge --help"`)
 		},
 	}
 
 	cobra.OnInitialize(initConfig)
 
+	// accepted so that "kubectl purge --yes" fails with the error above rather than an unknown flag
 	cmd.Flags().BoolP("yes", "y", false, "Delete without confirming")
+	_ = cmd.Flags().MarkHidden("yes")
+
+	addPurgeFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().IntP("v", "v", 0, "Log verbosity, 1 prints skipped objects and waits, 4 prints stack traces of errors and 6 or more traces API requests")
+	cmd.PersistentFlags().Bool("no-color", false, "Disable colors, they are also disabled when stdout isn't a terminal")
+	cmd.PersistentFlags().String("log-format", logger.FormatText, "Log format, text or json")
 
 	KubernetesConfigFlags = genericclioptions.NewConfigFlags(false)
-	KubernetesConfigFlags.AddFlags(cmd.Flags())
+	KubernetesConfigFlags.AddFlags(cmd.PersistentFlags())
+
+	registerCompletions(cmd)
+
+	cmd.AddCommand(clusterCmd())
+	cmd.AddCommand(namespaceCmd())
+	cmd.AddCommand(crdsCmd())
+	cmd.AddCommand(planCmd())
+	cmd.AddCommand(verifyCmd())
+	cmd.AddCommand(snapshotCmd())
+	cmd.AddCommand(janitorCmd())
+	cmd.AddCommand(renderManifestsCmd())
+	cmd.AddCommand(installCmd())
+	cmd.AddCommand(completionCmd())
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	return cmd
 }
 
+// addPurgeFlags adds the flags that configure a purge, shared by every subcommand and the in-cluster container
+func addPurgeFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("protected-context", []string{}, "Glob pattern of context names or server URLs that must never be purged")
+	flags.StringSlice("allowed-cluster-uid", []string{}, "UID of a kube-system namespace that may be purged, all other clusters are refused")
+	flags.Bool("i-know-what-im-doing", false, "Purge even if a guardrail refuses to")
+	flags.Duration("older-than", 0, "Only purge objects created at least this long ago, e.g. 24h")
+	flags.Duration("newer-than", 0, "Only purge objects created at most this long ago, e.g. 1h")
+	flags.Bool("namespace-last-activity", false, "Judge a namespace's age by the newest object inside it")
+	flags.Bool("allow-partial", false, "Purge the kinds you have permission to, instead of refusing to start")
+	flags.StringSlice("helm-release", []string{}, "Only purge this Helm release and its history, as name or namespace/name")
+	flags.Bool("helm-releases-only", false, "Only purge Helm releases and their history")
+	flags.Bool("verify", false, "After purging, list what is left and why, and fail if anything unexpected remains")
+	flags.Duration("verify-timeout", 2*time.Minute, "How long verifying waits for terminating objects to disappear")
+	flags.StringSlice("only", []string{}, "Only purge these resource types, e.g. deploy,cm or all")
+	flags.StringSlice("skip", []string{}, "Never purge these resource types, e.g. secrets,pvc")
+	flags.Bool("keep-storage", false, "Keep PersistentVolumeClaims and PersistentVolumes")
+	flags.Bool("retain-volumes", false, "With --keep-storage, set the volumes of purged namespaces to Retain and make them available to be bound again")
+	flags.String("profile", plugin.ProfileAuto, fmt.Sprintf("Distribution whose system objects are protected, one of: %s", strings.Join(plugin.ProfileNames(), ", ")))
+	flags.Bool("force-control-plane", false, "Delete control-plane objects too, e.g. the kubernetes Service and kube-root-ca.crt ConfigMaps")
+	flags.Bool("exhaustive", false, "Delete objects that have owners too, instead of leaving them to the garbage collector")
+	flags.Duration("dependents-timeout", 2*time.Minute, "How long to wait for the garbage collector to delete dependents before deleting them directly")
+	flags.Bool("gentle", false, "Scale workloads to zero, suspend CronJobs and wait for their pods to terminate gracefully before deleting")
+	flags.Bool("evict", false, "With --gentle, evict pods through the Eviction API, honouring PodDisruptionBudgets")
+	flags.Duration("drain-timeout", 5*time.Minute, "With --gentle, how long to wait for pods to terminate")
+	flags.Duration("stuck-pod-timeout", 5*time.Minute, "Force-delete pods still terminating this long after their deletion, if their node is NotReady or gone")
+	flags.Duration("load-balancer-timeout", 5*time.Minute, "How long to wait for the cloud to clean up the load balancers of deleted LoadBalancer Services")
+	flags.String("strategy", plugin.StrategyContentsFirst, fmt.Sprintf("How namespaces that are deleted are purged, one of: %s", strings.Join(plugin.StrategyNames(), ", ")))
+	flags.Duration("namespace-timeout", 2*time.Minute, "With --strategy=namespace-delete or hybrid, how long to wait for a namespace to be deleted before purging its contents kind by kind")
+	flags.String("policy-file", "", "Policy file declaring the hooks run before and after namespaces are purged")
+	flags.Bool("converge", false, "Repeat the purge until a pass finds nothing to delete, for controllers that recreate objects")
+	flags.Int("max-passes", 5, "With --converge, give up after this many passes")
+	flags.Duration("settle-interval", 10*time.Second, "With --converge, how long to wait between passes for controllers to recreate objects")
+	flags.String("to-baseline", "", "Snapshot file to purge back to, everything that isn't in it is deleted")
+}
+
+// newLogger creates a logger from the flags, and sets the verbosity of the client-go logs to match
+func newLogger() *logger.Logger {
+	verbosity := viper.GetInt("v")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	_ = klogFlags.Set("v", strconv.Itoa(verbosity))
+
+	return logger.NewLogger(logger.Options{
+		Verbosity: verbosity,
+		NoColor:   viper.GetBool("no-color"),
+		Format:    viper.GetString("log-format"),
+	})
+}
+
+// printLogs prints messages and errors while running, until both channels are closed
+func printLogs(log *logger.Logger) (chan string, chan error, *sync.WaitGroup) {
+	logCh := make(chan string, 1)
+	errorCh := make(chan error, 1)
+
+	logWaitGroup := &sync.WaitGroup{}
+	logWaitGroup.Add(2)
+	logMutex := &sync.Mutex{}
+	go func() {
+		defer logWaitGroup.Done()
+		for logStr := range logCh {
+			level, msg := plugin.MessageLevel(logStr)
+			logMutex.Lock()
+			log.V(level).Info(msg)
+			logMutex.Unlock()
+		}
+	}()
+	go func() {
+		defer logWaitGroup.Done()
+		for err := range errorCh {
+			logMutex.Lock()
+			log.Error(err)
+			logMutex.Unlock()
+		}
+	}()
+	return logCh, errorCh, logWaitGroup
+}
+
+func pluginOptions() plugin.Options {
+	return optionsFrom(viper.GetViper())
+}
+
+// optionsFrom reads the purge flags from v
+func optionsFrom(v *viper.Viper) plugin.Options {
+	return plugin.Options{
+		Guardrails: plugin.Guardrails{
+			ProtectedPatterns:  v.GetStringSlice("protected-context"),
+			AllowedClusterUIDs: v.GetStringSlice("allowed-cluster-uid"),
+			Override:           v.GetBool("i-know-what-im-doing"),
+		},
+		OlderThan:           v.GetDuration("older-than"),
+		NewerThan:           v.GetDuration("newer-than"),
+		NamespaceActivity:   v.GetBool("namespace-last-activity"),
+		HelmReleases:        v.GetStringSlice("helm-release"),
+		HelmReleasesOnly:    v.GetBool("helm-releases-only"),
+		AllowPartial:        v.GetBool("allow-partial"),
+		Verify:              v.GetBool("verify"),
+		VerifyTimeout:       v.GetDuration("verify-timeout"),
+		Only:                v.GetStringSlice("only"),
+		Skip:                v.GetStringSlice("skip"),
+		KeepStorage:         v.GetBool("keep-storage"),
+		RetainVolumes:       v.GetBool("retain-volumes"),
+		Profile:             v.GetString("profile"),
+		ForceControlPlane:   v.GetBool("force-control-plane"),
+		Exhaustive:          v.GetBool("exhaustive"),
+		DependentsTimeout:   v.GetDuration("dependents-timeout"),
+		Gentle:              v.GetBool("gentle"),
+		Evict:               v.GetBool("evict"),
+		DrainTimeout:        v.GetDuration("drain-timeout"),
+		StuckPodTimeout:     v.GetDuration("stuck-pod-timeout"),
+		LoadBalancerTimeout: v.GetDuration("load-balancer-timeout"),
+		Strategy:            v.GetString("strategy"),
+		NamespaceTimeout:    v.GetDuration("namespace-timeout"),
+		PolicyFile:          v.GetString("policy-file"),
+		Converge:            v.GetBool("converge"),
+		MaxPasses:           v.GetInt("max-passes"),
+		SettleInterval:      v.GetDuration("settle-interval"),
+		Baseline:            v.GetString("to-baseline"),
+	}
+}
+
 func InitAndExecute() {
 	if err := RootCmd().Execute(); err != nil {
-		fmt.Println(err)
+		newLogger().Error(err)
 		os.Exit(1)
 	}
 }
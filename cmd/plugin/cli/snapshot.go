@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/plugin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+)
+
+func snapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Record the objects on a clean cluster, to purge back to them later",
+		Long: `Snapshots record the identity of every object on the cluster: its kind,
+namespace, name and UID. Purging with --to-baseline deletes every object that
+isn't in the snapshot, so the cluster returns to exactly the recorded state.`,
+		Example: `  # record the cluster right after it was set up
+  kubectl purge snapshot save baseline.yaml
+
+  # later, delete everything that was added since
+  kubectl purge cluster --to-baseline=baseline.yaml`,
+	}
+	cmd.AddCommand(snapshotSaveCmd())
+	return cmd
+}
+
+func snapshotSaveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:           "save <file>",
+		Short:         "Write a snapshot of every object on the cluster to a file",
+		Args:          cobra.ExactArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return errors.Wrap(viper.BindPFlags(cmd.Flags()), "failed to bind flags")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := newLogger()
+			file, err := os.Create(args[0])
+			if err != nil {
+				return errors.Wrap(err, "failed to create snapshot file")
+			}
+			defer file.Close()
+
+			logCh, errorCh, logWaitGroup := printLogs(log)
+			err = plugin.SaveSnapshot(KubernetesConfigFlags, file, logCh, errorCh)
+			logWaitGroup.Wait()
+			return err
+		},
+	}
+}
@@ -1,35 +1,133 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
+	"golang.org/x/term"
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// errors include their stack trace from this verbosity on
+	stackTraceVerbosity = 4
+)
+
+type Options struct {
+	// messages logged with V(level) are only printed if level <= Verbosity
+	Verbosity int
+	// disable colors, they are also disabled when stdout isn't a terminal
+	NoColor bool
+	// FormatText or FormatJSON
+	Format string
+}
+
 type Logger struct {
+	out       io.Writer
+	verbosity int
+	color     bool
+	json      bool
+	mutex     *sync.Mutex
 }
 
-func NewLogger() *Logger {
-	return &Logger{}
+func NewLogger(opts Options) *Logger {
+	return &Logger{
+		out:       os.Stdout,
+		verbosity: opts.Verbosity,
+		color:     !opts.NoColor && term.IsTerminal(int(os.Stdout.Fd())),
+		json:      opts.Format == FormatJSON,
+		mutex:     &sync.Mutex{},
+	}
+}
+
+// V returns a logger that only prints if level is at most the configured verbosity
+func (l *Logger) V(level int) *Logger {
+	if level <= l.verbosity {
+		return l
+	}
+	return nil
+}
+
+// JSON reports whether messages are logged as JSON, one object per line
+func (l *Logger) JSON() bool {
+	return l.json
 }
 
 func (l *Logger) Info(msg string, args ...interface{}) {
-	if msg == "" {
-		fmt.Println("")
+	if l == nil {
 		return
 	}
-
-	c := color.New(color.FgHiCyan)
-	c.Println(fmt.Sprintf(msg, args...))
+	l.print("info", color.FgHiCyan, format(msg, args...), nil)
 }
 
+// Error prints the error's message, and its stack trace at high verbosity
 func (l *Logger) Error(err error) {
-	c := color.New(color.FgHiRed)
-	c.Println(fmt.Sprintf("%#v", err))
+	if l == nil {
+		return
+	}
+
+	fields := map[string]interface{}{}
+	msg := err.Error()
+	if l.verbosity >= stackTraceVerbosity {
+		stack := fmt.Sprintf("%+v", err)
+		if l.json {
+			fields["stack"] = stack
+		} else {
+			msg = stack
+		}
+	}
+	l.print("error", color.FgHiRed, msg, fields)
 }
 
 func (l *Logger) Instructions(msg string, args ...interface{}) {
-	white := color.New(color.FgHiWhite)
-	white.Println("")
-	white.Println(fmt.Sprintf(msg, args...))
+	if l == nil {
+		return
+	}
+	if !l.json {
+		l.print("info", color.FgHiWhite, "", nil)
+	}
+	l.print("info", color.FgHiWhite, format(msg, args...), nil)
+}
+
+func (l *Logger) print(level string, attribute color.Attribute, msg string, fields map[string]interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.json {
+		entry := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339),
+			"level": level,
+			"msg":   msg,
+		}
+		for key, value := range fields {
+			entry[key] = value
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			line = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+		}
+		fmt.Fprintln(l.out, string(line))
+		return
+	}
+
+	c := color.New(attribute)
+	if !l.color {
+		c.DisableColor()
+	}
+	c.Fprintln(l.out, msg)
+}
+
+// format only treats msg as a format string if there are args, so that '%' in plain messages is printed as-is
+func format(msg string, args ...interface{}) string {
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
 }
@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// verbs that are checked before purging, only list and delete are required
+var accessVerbs = []string{"list", "delete", "deletecollection"}
+
+// accessRow is one line of the permission matrix, namespace "*" means all namespaces
+type accessRow struct {
+	kind      purgeKind
+	namespace string
+	allowed   map[string]bool
+}
+
+func (r accessRow) canPurge() bool {
+	return r.allowed["list"] && r.allowed["delete"]
+}
+
+// accessMatrix records which kinds can't be purged, and where
+type accessMatrix struct {
+	rows []accessRow
+	// keyed by accessKey
+	denied map[string]bool
+	// namespaced kinds denied for all namespaces, they are only allowed in the namespaces reviewed for them
+	narrowed []purgeKind
+	// the narrowed kinds that are allowed, keyed by accessKey
+	allowed map[string]bool
+	// the namespaces the narrowed kinds were reviewed in
+	reviewed map[string]bool
+}
+
+func accessKey(kind purgeKind, namespace string) string {
+	return kind.resource.GroupResource().String() + "/" + namespace
+}
+
+// allows reports whether kind can be purged in namespace, "" for cluster-scoped kinds or all namespaces
+func (m *accessMatrix) allows(kind purgeKind, namespace string) bool {
+	if m.denied[accessKey(kind, "*")] || m.denied[accessKey(kind, namespace)] {
+		return false
+	}
+	for _, narrowed := range m.narrowed {
+		if narrowed.resource.GroupResource() == kind.resource.GroupResource() {
+			return m.allowed[accessKey(kind, namespace)]
+		}
+	}
+	return true
+}
+
+func (m *accessMatrix) complete() bool {
+	return len(m.denied) == 0
+}
+
+func (m *accessMatrix) String() string {
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tNAMESPACE\tLIST\tDELETE\tDELETECOLLECTION")
+	for _, row := range m.rows {
+		namespace := row.namespace
+		if !row.kind.namespaced {
+			namespace = "(cluster)"
+		}
+		fmt.Fprintf(writer, "%s\t%s", row.kind.resource.GroupResource(), namespace)
+		for _, verb := range accessVerbs {
+			fmt.Fprintf(writer, "\t%s", yesNo(row.allowed[verb]))
+		}
+		fmt.Fprintln(writer)
+	}
+	writer.Flush()
+	return buffer.String()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// how many access reviews are sent at once
+const accessReviewConcurrency = 10
+
+// checkAccess runs a SelfSubjectAccessReview for every verb on every kind in the plan, for all namespaces.
+// With perNamespace, the namespaced kinds denied there are checked with a single SelfSubjectRulesReview per namespace,
+// which falls back to access reviews where the rules are incomplete. Otherwise they are purged across all namespaces,
+// so they stay denied.
+func checkAccess(ctx context.Context, clientset *kubernetes.Clientset, kinds []purgeKind, namespaces []string, perNamespace bool) (*accessMatrix, error) {
+	matrix := &accessMatrix{denied: map[string]bool{}, allowed: map[string]bool{}, reviewed: map[string]bool{}}
+	matrixMutex := &sync.Mutex{}
+	waitGroup := sync.WaitGroup{}
+	semaphore := make(chan struct{}, accessReviewConcurrency)
+
+	var reviewErr error
+	for _, kind := range kinds {
+		waitGroup.Add(1)
+
+		kind := kind
+		go func() {
+			defer waitGroup.Done()
+			semaphore <- struct{}{}
+			row, err := reviewAccess(ctx, clientset, kind, "")
+			<-semaphore
+
+			matrixMutex.Lock()
+			defer matrixMutex.Unlock()
+			if err != nil {
+				reviewErr = err
+				return
+			}
+			if perNamespace && kind.namespaced && !row.canPurge() {
+				matrix.narrowed = append(matrix.narrowed, kind)
+				return
+			}
+			matrix.add(row)
+		}()
+	}
+	waitGroup.Wait()
+	if reviewErr != nil {
+		return nil, reviewErr
+	}
+
+	if _, err := matrix.reviewNamespaces(ctx, clientset, namespaces); err != nil {
+		return nil, err
+	}
+	matrix.sortRows()
+	return matrix, nil
+}
+
+// reviewNamespaces checks the narrowed kinds in the namespaces that weren't reviewed yet, and returns the new rows
+func (m *accessMatrix) reviewNamespaces(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) ([]accessRow, error) {
+	if m == nil || len(m.narrowed) == 0 {
+		return nil, nil
+	}
+
+	matrixMutex := &sync.Mutex{}
+	waitGroup := sync.WaitGroup{}
+	semaphore := make(chan struct{}, accessReviewConcurrency)
+
+	var reviewErr error
+	var added []accessRow
+	for _, namespace := range namespaces {
+		if m.reviewed[namespace] {
+			continue
+		}
+		m.reviewed[namespace] = true
+		waitGroup.Add(1)
+
+		namespace := namespace
+		go func() {
+			defer waitGroup.Done()
+			semaphore <- struct{}{}
+			rows, err := reviewNamespaceAccess(ctx, clientset, m.narrowed, namespace)
+			<-semaphore
+
+			matrixMutex.Lock()
+			defer matrixMutex.Unlock()
+			if err != nil {
+				reviewErr = err
+				return
+			}
+			added = append(added, rows...)
+		}()
+	}
+	waitGroup.Wait()
+	if reviewErr != nil {
+		return nil, reviewErr
+	}
+
+	for _, row := range added {
+		m.add(row)
+	}
+	m.sortRows()
+	return added, nil
+}
+
+func (m *accessMatrix) add(row accessRow) {
+	m.rows = append(m.rows, row)
+	if !row.canPurge() {
+		m.denied[accessKey(row.kind, row.namespace)] = true
+	} else if row.namespace != "*" {
+		m.allowed[accessKey(row.kind, row.namespace)] = true
+	}
+}
+
+// sortRows sorts the rows by kind, and then by namespace
+func (m *accessMatrix) sortRows() {
+	sort.SliceStable(m.rows, func(i, j int) bool {
+		if m.rows[i].kind.resource != m.rows[j].kind.resource {
+			return m.rows[i].kind.resource.GroupResource().String() < m.rows[j].kind.resource.GroupResource().String()
+		}
+		return m.rows[i].namespace < m.rows[j].namespace
+	})
+}
+
+// reviewNamespaceAccess checks kinds in namespace with one SelfSubjectRulesReview. If the authorizer can't list
+// every rule, e.g. with webhook authorization, the kinds are checked with access reviews instead.
+func reviewNamespaceAccess(ctx context.Context, clientset *kubernetes.Clientset, kinds []purgeKind, namespace string) ([]accessRow, error) {
+	review := &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}
+	result, err := clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to review access in: %s", namespace))
+	}
+
+	rows := make([]accessRow, 0, len(kinds))
+	for _, kind := range kinds {
+		if result.Status.Incomplete {
+			row, err := reviewAccess(ctx, clientset, kind, namespace)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+			continue
+		}
+
+		row := accessRow{kind: kind, namespace: namespace, allowed: map[string]bool{}}
+		for _, verb := range accessVerbs {
+			row.allowed[verb] = rulesAllow(result.Status.ResourceRules, kind, verb)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// rulesAllow reports whether one of rules grants verb on every object of kind
+func rulesAllow(rules []authorizationv1.ResourceRule, kind purgeKind, verb string) bool {
+	for _, rule := range rules {
+		// rules limited to some names don't allow purging the kind
+		if len(rule.ResourceNames) > 0 {
+			continue
+		}
+		if matchesRule(rule.Verbs, verb) && matchesRule(rule.APIGroups, kind.resource.Group) && matchesRule(rule.Resources, kind.resource.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRule(values []string, value string) bool {
+	for _, ruleValue := range values {
+		if ruleValue == "*" || ruleValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// reviewAccess checks every verb on kind in namespace, "" means all namespaces for namespaced kinds
+func reviewAccess(ctx context.Context, clientset *kubernetes.Clientset, kind purgeKind, namespace string) (accessRow, error) {
+	row := accessRow{kind: kind, namespace: namespace, allowed: map[string]bool{}}
+	if kind.namespaced && namespace == "" {
+		row.namespace = "*"
+	}
+
+	for _, verb := range accessVerbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     kind.resource.Group,
+					Resource:  kind.resource.Resource,
+				},
+			},
+		}
+
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return row, errors.Wrap(err, fmt.Sprintf("failed to review access to %s %s", verb, kind.resource.GroupResource()))
+		}
+		row.allowed[verb] = result.Status.Allowed
+	}
+	return row, nil
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// selectsNamespace applies the age filters to a namespace.
+// With NamespaceActivity the newest object inside the namespace decides, so a namespace that is still in use is kept.
+func selectsNamespace(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, namespace corev1.Namespace, opts Options) (bool, error) {
+	if !opts.filtersAge() {
+		return true, nil
+	}
+	if !opts.NamespaceActivity {
+		return opts.selectsAge(namespace.CreationTimestamp), nil
+	}
+
+	lastActivity, err := namespaceLastActivity(ctx, clientset, dynamicClient, namespace)
+	if err != nil {
+		return false, err
+	}
+	return opts.selectsAge(lastActivity), nil
+}
+
+// namespaceLastActivity returns the newest creationTimestamp of the namespace and every listable object inside it
+func namespaceLastActivity(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, namespace corev1.Namespace) (metav1.Time, error) {
+	lastActivity := namespace.CreationTimestamp
+
+	resourceLists, err := clientset.Discovery().ServerPreferredNamespacedResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return lastActivity, errors.Wrap(err, "failed to discover namespaced resources")
+	}
+
+	listable := discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, resourceLists)
+	for _, resourceList := range listable {
+		groupVersion, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return lastActivity, errors.Wrap(err, fmt.Sprintf("failed to parse group version %s", resourceList.GroupVersion))
+		}
+
+		for _, resource := range resourceList.APIResources {
+			objects, err := dynamicClient.Resource(groupVersion.WithResource(resource.Name)).Namespace(namespace.Name).List(ctx, metav1.ListOptions{})
+			if apierrors.IsForbidden(err) {
+				// e.g. in-cluster, where only the kinds a purge can delete may be listed
+				continue
+			}
+			if err != nil {
+				return lastActivity, errors.Wrap(err, fmt.Sprintf("failed to list %s in namespace: %s", resource.Name, namespace.Name))
+			}
+
+			for _, object := range objects.Items {
+				created := object.GetCreationTimestamp()
+				if lastActivity.Before(&created) {
+					lastActivity = created
+				}
+			}
+		}
+	}
+	return lastActivity, nil
+}
+
+// holdsObjectsOutsideAgeFilter reports whether namespace holds an object the age filters keep, which deleting the namespace
+// would delete along with it. Objects with owners follow their owners, and kinds that may not be listed are left out.
+func holdsObjectsOutsideAgeFilter(ctx context.Context, c *clients, namespace string, opts Options) (bool, error) {
+	if !opts.filtersAge() {
+		return false, nil
+	}
+
+	kinds, err := discoverNamespacedKinds(c)
+	if err != nil {
+		return false, err
+	}
+	for _, kind := range kinds {
+		// events are recorded all the time, also about the purge itself
+		if kind.resource.Resource == eventsKind.resource.Resource {
+			continue
+		}
+
+		list, err := c.dynamicClient.Resource(kind.resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
+		if err != nil {
+			return false, errors.Wrap(err, fmt.Sprintf("failed to list %s in namespace: %s", kind.name, namespace))
+		}
+
+		for i := range list.Items {
+			object := &list.Items[i]
+			if object.GetDeletionTimestamp() != nil || len(object.GetOwnerReferences()) > 0 {
+				continue
+			}
+			if opts.keepReason(kind, object, object) == outsideAgeFilter {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func ago(d time.Duration) metav1.Time {
+	return metav1.NewTime(time.Now().Add(-d))
+}
+
+func TestSelectsAge(t *testing.T) {
+	tests := []struct {
+		name      string
+		olderThan time.Duration
+		newerThan time.Duration
+		created   metav1.Time
+		want      bool
+	}{
+		{name: "no filters", created: ago(time.Minute), want: true},
+		{name: "older than, old enough", olderThan: 24 * time.Hour, created: ago(48 * time.Hour), want: true},
+		{name: "older than, too new", olderThan: 24 * time.Hour, created: ago(time.Hour), want: false},
+		{name: "newer than, new enough", newerThan: time.Hour, created: ago(time.Minute), want: true},
+		{name: "newer than, too old", newerThan: time.Hour, created: ago(2 * time.Hour), want: false},
+		{name: "window, inside", olderThan: time.Hour, newerThan: 24 * time.Hour, created: ago(2 * time.Hour), want: true},
+		{name: "window, too new", olderThan: time.Hour, newerThan: 24 * time.Hour, created: ago(time.Minute), want: false},
+		{name: "window, too old", olderThan: time.Hour, newerThan: 24 * time.Hour, created: ago(48 * time.Hour), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Options{OlderThan: test.olderThan, NewerThan: test.newerThan}
+			if got := opts.selectsAge(test.created); got != test.want {
+				t.Errorf("selectsAge() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKeepReasonOutsideAgeFilter(t *testing.T) {
+	opts := Options{OlderThan: 24 * time.Hour}
+	fresh := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "fresh", CreationTimestamp: ago(time.Hour)}}
+	old := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "old", CreationTimestamp: ago(48 * time.Hour)}}
+	protected := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         "dev",
+		Name:              "protected",
+		CreationTimestamp: ago(time.Hour),
+		Labels:            map[string]string{protectedLabel: "true"},
+	}}
+
+	if reason := opts.keepReason(configMapsKind, fresh, fresh); reason != outsideAgeFilter {
+		t.Errorf("expected fresh to be %q, got %q", outsideAgeFilter, reason)
+	}
+	if reason := opts.keepReason(configMapsKind, old, old); reason != "" {
+		t.Errorf("expected old to be deleted, got %q", reason)
+	}
+	// the other reasons are more specific, and are logged
+	if reason := opts.keepReason(configMapsKind, protected, protected); reason != "protected" {
+		t.Errorf("expected protected to be protected, got %q", reason)
+	}
+}
+
+func TestSelectsNamespaceByCreation(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		created metav1.Time
+		want    bool
+	}{
+		{name: "no filters", created: ago(time.Minute), want: true},
+		{name: "old enough", opts: Options{OlderThan: 24 * time.Hour}, created: ago(48 * time.Hour), want: true},
+		{name: "too new", opts: Options{OlderThan: 24 * time.Hour}, created: ago(time.Hour), want: false},
+		{name: "too old", opts: Options{NewerThan: time.Hour}, created: ago(48 * time.Hour), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", CreationTimestamp: test.created}}
+			// without NamespaceActivity the cluster isn't asked for anything
+			got, err := selectsNamespace(context.Background(), nil, nil, namespace, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("selectsNamespace() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
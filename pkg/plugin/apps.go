@@ -1,70 +1,54 @@
 package plugin
 
 import (
-	"fmt"
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"sync"
 )
 
-func deleteDeployments(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteDeployments(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(deploymentsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.AppsV1().Deployments(namespace)
+
 	deployments, err := api.List(ctx, metav1.ListOptions{})
 	if err != nil {
 		errorCh <- errors.Wrap(err, "failed to list deployments")
 		return
 	}
-	for _, deployment := range deployments.Items {
-		waitGroup.Add(1)
-
-		deploymentName := deployment.Name
-		go func() {
-			defer waitGroup.Done()
-			if err := api.Delete(ctx, deploymentName, deletePolicy); err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete deployment %s", deploymentName))
-			}
-		}()
-	}
-
-	waitGroup.Wait()
+	deleteObjects(ctx, deploymentsKind, namespace, deployments, api.Delete, opts, logCh, errorCh)
 }
 
-func deleteDaemonSets(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteDaemonSets(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(daemonSetsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
 
 	api := clientset.AppsV1().DaemonSets(namespace)
+
 	daemonSets, err := api.List(ctx, metav1.ListOptions{})
 	if err != nil {
 		errorCh <- errors.Wrap(err, "failed to list daemonSets")
 		return
 	}
+	deleteObjects(ctx, daemonSetsKind, namespace, daemonSets, api.Delete, opts, logCh, errorCh)
+}
 
-	waitGroup := sync.WaitGroup{}
-	for _, daemonSet := range daemonSets.Items {
-		waitGroup.Add(1)
-
-		daemonSetName := daemonSet.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, daemonSetName, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete daemonSet %s", daemonSetName))
-			}
-		}()
+func deleteStatefulSets(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(statefulSetsKind, namespace) {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deleteStatefulSets(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.AppsV1().StatefulSets(namespace)
 
@@ -73,25 +57,16 @@ func deleteStatefulSets(clientset *kubernetes.Clientset, namespace string, logCh
 		errorCh <- errors.Wrap(err, "failed to list statefulSets")
 		return
 	}
-	for _, statefulSet := range statefulSets.Items {
-		waitGroup.Add(1)
-
-		name := statefulSet.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete statefulSet %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, statefulSetsKind, namespace, statefulSets, api.Delete, opts, logCh, errorCh)
 }
 
-func deleteReplicaSets(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteReplicaSets(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(replicaSetsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.AppsV1().ReplicaSets(namespace)
 
@@ -100,17 +75,5 @@ func deleteReplicaSets(clientset *kubernetes.Clientset, namespace string, logCh
 		errorCh <- errors.Wrap(err, "failed to list replicaSets")
 		return
 	}
-	for _, replicaSet := range replicaSets.Items {
-		waitGroup.Add(1)
-
-		name := replicaSet.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete replicaSet %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, replicaSetsKind, namespace, replicaSets, api.Delete, opts, logCh, errorCh)
 }
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// controlPlaneObject is an object that only the control plane creates, and that may not be recreated once deleted
+type controlPlaneObject struct {
+	resource schema.GroupResource
+	// "" matches every namespace
+	namespace string
+	name      string
+}
+
+var controlPlaneObjects = []controlPlaneObject{
+	// the API server's own Service
+	{schema.GroupResource{Resource: "services"}, metav1.NamespaceDefault, "kubernetes"},
+	{schema.GroupResource{Resource: "endpoints"}, metav1.NamespaceDefault, "kubernetes"},
+	{schema.GroupResource{Group: "discovery.k8s.io", Resource: "endpointslices"}, metav1.NamespaceDefault, "kubernetes"},
+	// published into every namespace by the root CA controller
+	{schema.GroupResource{Resource: "configmaps"}, "", "kube-root-ca.crt"},
+	// created in every namespace by the service account controller
+	{schema.GroupResource{Resource: "serviceaccounts"}, "", "default"},
+}
+
+// isControlPlaneObject reports whether the object is owned by the control plane, including the token Secrets of the default ServiceAccount
+func isControlPlaneObject(kind purgeKind, item runtime.Object, object metav1.Object) bool {
+	for _, controlPlane := range controlPlaneObjects {
+		if controlPlane.resource == kind.resource.GroupResource() &&
+			(controlPlane.namespace == "" || controlPlane.namespace == object.GetNamespace()) &&
+			controlPlane.name == object.GetName() {
+			return true
+		}
+	}
+
+	if kind.resource.GroupResource() == secretsKind.resource.GroupResource() &&
+		secretType(item) == corev1.SecretTypeServiceAccountToken &&
+		object.GetAnnotations()[corev1.ServiceAccountNameKey] == "default" {
+		return true
+	}
+	return false
+}
+
+// secretType reads the type of a typed or unstructured Secret
+func secretType(item runtime.Object) corev1.SecretType {
+	switch secret := item.(type) {
+	case *corev1.Secret:
+		return secret.Type
+	case *unstructured.Unstructured:
+		secretType, _, _ := unstructured.NestedString(secret.Object, "type")
+		return corev1.SecretType(secretType)
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// passTracker counts the objects a purge pass found to delete, per kind
+type passTracker struct {
+	mutex sync.Mutex
+	found map[string]int
+}
+
+func newPassTracker() *passTracker {
+	return &passTracker{found: map[string]int{}}
+}
+
+func (t *passTracker) add(kind purgeKind, count int) {
+	if t == nil || count == 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.found[kind.name] += count
+}
+
+func (t *passTracker) total() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	total := 0
+	for _, count := range t.found {
+		total += count
+	}
+	return total
+}
+
+// recurringKinds tracks the kinds that were found again after the first pass, i.e. that something recreated
+type recurringKinds map[string]int
+
+func (r recurringKinds) add(pass *passTracker) {
+	pass.mutex.Lock()
+	defer pass.mutex.Unlock()
+	for kind, count := range pass.found {
+		r[kind] += count
+	}
+}
+
+func (r recurringKinds) String() string {
+	kinds := make([]string, 0, len(r))
+	for kind := range r {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	descriptions := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%d)", kind, r[kind]))
+	}
+	return strings.Join(descriptions, ", ")
+}
@@ -3,15 +3,28 @@ package plugin
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
-	"sync"
+	"time"
 )
 
-func deleteConfigMaps(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+// how often the pods stuck terminating on unavailable nodes are checked
+const stuckPodsPollInterval = 5 * time.Second
+
+var removeFinalizersPatch = []byte(`{"metadata":{"finalizers":null}}`)
+
+func deleteConfigMaps(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(configMapsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.CoreV1().ConfigMaps(namespace)
 
@@ -20,25 +33,16 @@ func deleteConfigMaps(clientset *kubernetes.Clientset, namespace string, logCh c
 		errorCh <- errors.Wrap(err, "failed to list configMaps")
 		return
 	}
-	for _, configMap := range configMaps.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, configMapsKind, namespace, configMaps, api.Delete, opts, logCh, errorCh)
+}
 
-		name := configMap.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete configMap %s", name))
-			}
-		}()
+func deleteEndpoints(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(endpointsKind, namespace) {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deleteEndpoints(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.CoreV1().Endpoints(namespace)
 
@@ -47,25 +51,16 @@ func deleteEndpoints(clientset *kubernetes.Clientset, namespace string, logCh ch
 		errorCh <- errors.Wrap(err, "failed to list endpoints")
 		return
 	}
-	for _, endpoint := range endpoints.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, endpointsKind, namespace, endpoints, api.Delete, opts, logCh, errorCh)
+}
 
-		name := endpoint.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete endpoint %s", name))
-			}
-		}()
+func deletePersistentVolumeClaims(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(persistentVolumeClaimsKind, namespace) {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deletePersistentVolumeClaims(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.CoreV1().PersistentVolumeClaims(namespace)
 
@@ -74,25 +69,16 @@ func deletePersistentVolumeClaims(clientset *kubernetes.Clientset, namespace str
 		errorCh <- errors.Wrap(err, "failed to list persistentVolumeClaims")
 		return
 	}
-	for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, persistentVolumeClaimsKind, namespace, persistentVolumeClaims, api.Delete, opts, logCh, errorCh)
+}
 
-		name := persistentVolumeClaim.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete persistentVolumeClaim %s", name))
-			}
-		}()
+func deletePersistentVolumes(clientset *kubernetes.Clientset, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(persistentVolumesKind, "") {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deletePersistentVolumes(clientset *kubernetes.Clientset, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.CoreV1().PersistentVolumes()
 
@@ -101,25 +87,16 @@ func deletePersistentVolumes(clientset *kubernetes.Clientset, logCh chan<- strin
 		errorCh <- errors.Wrap(err, "failed to list persistentVolumes")
 		return
 	}
-	for _, persistentVolume := range persistentVolumes.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, persistentVolumesKind, "", persistentVolumes, api.Delete, opts, logCh, errorCh)
+}
 
-		name := persistentVolume.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete persistentVolume %s", name))
-			}
-		}()
+func deleteSecrets(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(secretsKind, namespace) {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deleteSecrets(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.CoreV1().Secrets(namespace)
 
@@ -128,17 +105,247 @@ func deleteSecrets(clientset *kubernetes.Clientset, namespace string, logCh chan
 		errorCh <- errors.Wrap(err, "failed to list secrets")
 		return
 	}
-	for _, secret := range secrets.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, secretsKind, namespace, secrets, api.Delete, opts, logCh, errorCh)
+}
+
+func deleteServices(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(servicesKind, namespace) {
+		return
+	}
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.CoreV1().Services(namespace)
+
+	services, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list services")
+		return
+	}
+	// load balancers are deleted before the namespace is purged, see deleteLoadBalancers
+	var items []corev1.Service
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			items = append(items, service)
+		}
+	}
+	services.Items = items
+	deleteObjects(ctx, servicesKind, namespace, services, api.Delete, opts, logCh, errorCh)
+}
+
+func deleteServiceAccounts(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(serviceAccountsKind, namespace) {
+		return
+	}
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.CoreV1().ServiceAccounts(namespace)
+
+	serviceAccounts, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list serviceAccounts")
+		return
+	}
+	deleteObjects(ctx, serviceAccountsKind, namespace, serviceAccounts, api.Delete, opts, logCh, errorCh)
+}
+
+func deleteResourceQuotas(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(resourceQuotasKind, namespace) {
+		return
+	}
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.CoreV1().ResourceQuotas(namespace)
+
+	resourceQuotas, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list resourceQuotas")
+		return
+	}
+	deleteObjects(ctx, resourceQuotasKind, namespace, resourceQuotas, api.Delete, opts, logCh, errorCh)
+}
+
+func deleteLimitRanges(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(limitRangesKind, namespace) {
+		return
+	}
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.CoreV1().LimitRanges(namespace)
+
+	limitRanges, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list limitRanges")
+		return
+	}
+	deleteObjects(ctx, limitRangesKind, namespace, limitRanges, api.Delete, opts, logCh, errorCh)
+}
+
+func deleteEndpointSlices(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(endpointSlicesKind, namespace) {
+		return
+	}
 
-		name := secret.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete secret %s", name))
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.DiscoveryV1().EndpointSlices(namespace)
+
+	endpointSlices, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list endpointSlices")
+		return
+	}
+	deleteObjects(ctx, endpointSlicesKind, namespace, endpointSlices, api.Delete, opts, logCh, errorCh)
+}
+
+// deletePods deletes the pods without an owner, owned pods are deleted with their controllers
+func deletePods(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(podsKind, namespace) {
+		return
+	}
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	api := clientset.CoreV1().Pods(namespace)
+
+	pods, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list pods")
+		return
+	}
+	deleteFn := func(ctx context.Context, name string, deleteOptions metav1.DeleteOptions) error {
+		// gently deleted pods get their own grace period
+		if opts.Gentle {
+			deleteOptions.GracePeriodSeconds = nil
+		}
+		return api.Delete(ctx, name, deleteOptions)
+	}
+	deleteObjects(ctx, podsKind, namespace, pods, deleteFn, opts, logCh, errorCh)
+}
+
+// forceDeleteStuckPods force-deletes the pods in namespace that are still terminating StuckPodTimeout after their deletion,
+// because their node is NotReady or gone and the kubelet can't confirm they stopped
+func forceDeleteStuckPods(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(podsKind, namespace) || opts.DryRun {
+		return
+	}
+
+	stuck, err := stuckPods(ctx, clientset, namespace)
+	if err != nil {
+		errorCh <- err
+		return
+	}
+	if len(stuck) == 0 {
+		return
+	}
+
+	// wait until the pod stuck for the shortest time is past the timeout too
+	longestWait := time.Duration(0)
+	for _, pod := range stuck {
+		if remaining := time.Until(pod.DeletionTimestamp.Add(opts.StuckPodTimeout)); remaining > longestWait {
+			longestWait = remaining
+		}
+	}
+	logCh <- fmt.Sprintf("%d pods in %s are terminating on unavailable nodes, force-deleting them in up to %s", len(stuck), namespace, longestWait.Round(time.Second))
+
+	forced := map[types.UID]bool{}
+	_ = wait.PollImmediate(stuckPodsPollInterval, longestWait+stuckPodsPollInterval, func() (bool, error) {
+		stuck, err := stuckPods(ctx, clientset, namespace)
+		if err != nil {
+			errorCh <- err
+			return false, nil
+		}
+
+		done := true
+		for _, pod := range stuck {
+			if forced[pod.UID] {
+				continue
 			}
-		}()
+			if time.Since(pod.DeletionTimestamp.Time) < opts.StuckPodTimeout {
+				done = false
+				continue
+			}
+			forced[pod.UID] = true
+			forceDeletePod(ctx, clientset, pod, logCh, errorCh)
+		}
+		return done, nil
+	})
+}
+
+// stuckPods lists the terminating pods in namespace whose node is NotReady or gone
+func stuckPods(ctx context.Context, clientset *kubernetes.Clientset, namespace string) ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to list pods in: %s", namespace))
+	}
+
+	var terminating []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil && pod.Spec.NodeName != "" {
+			terminating = append(terminating, pod)
+		}
+	}
+	if len(terminating) == 0 {
+		return nil, nil
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+	ready := map[string]bool{}
+	for _, node := range nodes.Items {
+		ready[node.Name] = isNodeReady(node)
+	}
+
+	var stuck []corev1.Pod
+	for _, pod := range terminating {
+		if !ready[pod.Spec.NodeName] {
+			stuck = append(stuck, pod)
+		}
+	}
+	return stuck, nil
+}
+
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// forceDeletePod removes the finalizers of pod and deletes it without waiting for the kubelet
+func forceDeletePod(ctx context.Context, clientset *kubernetes.Clientset, pod corev1.Pod, logCh chan<- string, errorCh chan<- error) {
+	api := clientset.CoreV1().Pods(pod.Namespace)
+	logCh <- fmt.Sprintf("Force-deleting pod stuck on node %s: %s", pod.Spec.NodeName, objectName(&pod))
+
+	if len(pod.Finalizers) > 0 {
+		_, err := api.Patch(ctx, pod.Name, types.MergePatchType, removeFinalizersPatch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to remove finalizers of pod %s", pod.Name))
+			return
+		}
+	}
+
+	err := api.Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+		Preconditions:      metav1.NewUIDPreconditions(string(pod.UID)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to force-delete pod %s", pod.Name))
 	}
-	waitGroup.Wait()
 }
@@ -3,15 +3,23 @@ package plugin
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
 	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	dynamic "k8s.io/client-go/dynamic"
 	"sync"
 )
 
-func deleteClusterCrds(apixClient *apixv1client.ApiextensionsV1Client, dynamicClient dynamic.Interface, errorCh chan<- error) {
+func deleteClusterCrds(apixClient *apixv1client.ApiextensionsV1Client, dynamicClient dynamic.Interface, opts Options, logCh chan<- string, errorCh chan<- error) {
+	// with --only or --skip, custom resources can be purged while their CRDs are kept
+	deletesCrds := opts.runsKind(crdsKind, "")
+	if !deletesCrds && opts.kindFilter == nil {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
 	waitGroup := sync.WaitGroup{}
@@ -21,61 +29,102 @@ func deleteClusterCrds(apixClient *apixv1client.ApiextensionsV1Client, dynamicCl
 		errorCh <- errors.Wrap(err, "failed to list cluster-wide crds")
 		return
 	}
+	var selected []apixv1.CustomResourceDefinition
+	var deleted []string
 	for _, crd := range crds.Items {
+		// already being deleted, e.g. waiting for its custom resources
+		if crd.DeletionTimestamp != nil {
+			continue
+		}
+		if opts.profile.protects(crdsKind, crd.Name) {
+			logCh <- verbose(fmt.Sprintf("Skipping crd of the %s profile: %s", opts.profile.name, crd.Name))
+			continue
+		}
+		if !opts.selectsCrd(crd) {
+			continue
+		}
+		// deleting a CRD deletes its custom resources too, so both have to take part in the run
+		if deletesCrds && opts.runsKind(customResourceKind(crd), "") && opts.selectsAge(crd.CreationTimestamp) {
+			selected = append(selected, crd)
+			deleted = append(deleted, crd.Name)
+		} else if opts.runsKind(customResourceKind(crd), "") {
+			selected = append(selected, crd)
+		}
+	}
+	opts.pass.add(crdsKind, len(deleted))
+	opts.reportProgress(ProgressEvent{Kind: crdsKind.name, Found: len(deleted)})
+
+	for _, crd := range selected {
 		waitGroup.Add(1)
 
 		name := crd.Name
 		crd := crd
 		go func() {
 			defer waitGroup.Done()
-			deleteCustomResources(&dynamicClient, crd, "", errorCh)
+			// namespaced custom resources are deleted with their namespace, unless only CRDs are purged
+			if crd.Spec.Scope != apixv1.NamespaceScoped || opts.Scope == ScopeCrds {
+				deleteCustomResources(&dynamicClient, crd, "", opts, logCh, errorCh)
+			}
+			if !util.Contains(deleted, name) {
+				return
+			}
+			if opts.DryRun {
+				logCh <- fmt.Sprintf("Would delete crd: %s", name)
+				return
+			}
 			err := apixClient.CustomResourceDefinitions().Delete(ctx, name, deletePolicy)
 			if err != nil {
 				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete crd %s", name))
+				opts.reportProgress(ProgressEvent{Kind: crdsKind.name, Failed: 1})
+				return
 			}
+			opts.reportProgress(ProgressEvent{Kind: crdsKind.name, Deleted: 1})
 		}()
 	}
 	waitGroup.Wait()
 }
 
-func deleteNamespacedCrds(apixClient *apixv1client.ApiextensionsV1Client, dynamicClient dynamic.Interface, namespace string, errorCh chan<- error) {
+// deleteNamespacedCustomResources deletes the custom resources in namespace, the CRDs are deleted with the cluster
+func deleteNamespacedCustomResources(apixClient *apixv1client.ApiextensionsV1Client, dynamicClient dynamic.Interface, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
 	waitGroup := sync.WaitGroup{}
 
-	crds, err := apixClient.CustomResourceDefinitions().List(ctx, metav1.ListOptions{
-		FieldSelector: fields.SelectorFromSet(fields.Set{"metadata.namespace": namespace}).String(),
-	})
+	crds, err := apixClient.CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list namespaced crds in: %s", namespace))
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list crds for namespace: %s", namespace))
 		return
 	}
 	for _, crd := range crds.Items {
+		if crd.Spec.Scope != apixv1.NamespaceScoped || opts.profile.protects(crdsKind, crd.Name) || !opts.selectsCrd(crd) {
+			continue
+		}
+
 		waitGroup.Add(1)
-		name := crd.Name
 		crd := crd
 
 		go func() {
 			defer waitGroup.Done()
-			deleteCustomResources(&dynamicClient, crd, namespace, errorCh)
-			err = apixClient.CustomResourceDefinitions().Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete crd %s", name))
-			}
+			deleteCustomResources(&dynamicClient, crd, namespace, opts, logCh, errorCh)
 		}()
 	}
 	waitGroup.Wait()
 }
 
-func deleteCustomResources(dynamicClient *dynamic.Interface, crd apixv1.CustomResourceDefinition, namespace string, errorCh chan<- error) {
+// deleteCustomResources deletes the custom resources of crd in namespace, or in every namespace if namespace is ""
+func deleteCustomResources(dynamicClient *dynamic.Interface, crd apixv1.CustomResourceDefinition, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	kind := customResourceKind(crd)
+	if !opts.runsKind(kind, namespace) {
+		return
+	}
+
 	name := crd.Name
 	ctx, cancel := createCtx()
 	defer cancel()
 
-	crdGvr := crd.GroupVersionKind().GroupVersion().WithResource(name)
-	crApi := (*dynamicClient).Resource(crdGvr)
+	crApi := (*dynamicClient).Resource(kind.resource)
 
-	customResources, err := crApi.List(ctx, metav1.ListOptions{})
+	customResources, err := crApi.Namespace(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		var errorMsg string
 		if namespace != "" {
@@ -87,17 +136,26 @@ func deleteCustomResources(dynamicClient *dynamic.Interface, crd apixv1.CustomRe
 		return
 	}
 
-	crWaitGroup := sync.WaitGroup{}
+	// namespaced custom resources listed across namespaces have to be deleted in their own namespace
+	byNamespace := map[string]*unstructured.UnstructuredList{}
 	for _, customResource := range customResources.Items {
-		crWaitGroup.Add(1)
-		customResource := customResource
-		go func() {
-			defer crWaitGroup.Done()
-			err := crApi.Delete(ctx, customResource.GetName(), deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete CustomResource %s for crd: %s in namespace: %s", customResource.GetName(), name, namespace))
-			}
-		}()
+		list, ok := byNamespace[customResource.GetNamespace()]
+		if !ok {
+			list = &unstructured.UnstructuredList{}
+			byNamespace[customResource.GetNamespace()] = list
+		}
+		list.Items = append(list.Items, customResource)
+	}
+
+	for crNamespace, list := range byNamespace {
+		if !opts.runsKind(kind, crNamespace) {
+			continue
+		}
+
+		crNamespace := crNamespace
+		deleteFn := func(ctx context.Context, crName string, deleteOptions metav1.DeleteOptions) error {
+			return crApi.Namespace(crNamespace).Delete(ctx, crName, deleteOptions)
+		}
+		deleteObjects(ctx, kind, crNamespace, list, deleteFn, opts, logCh, errorCh)
 	}
-	crWaitGroup.Wait()
 }
@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sync"
+)
+
+// objects labeled with this set to "true" are never deleted, e.g. the ones created by render-manifests
+const protectedLabel = "purge.kubectl.io/protected"
+
+// deleteFunc matches the Delete method of the typed clients
+type deleteFunc func(ctx context.Context, name string, opts metav1.DeleteOptions) error
+
+// the keepReason of objects outside of the OlderThan and NewerThan filters
+const outsideAgeFilter = "outside of the age filter"
+
+// keepReason returns why the object is kept regardless of the run, e.g. "protected", or "" if it may be deleted.
+// The reason is logged when the object is skipped, apart from outsideAgeFilter.
+func (o Options) keepReason(kind purgeKind, item runtime.Object, object metav1.Object) string {
+	switch {
+	case object.GetLabels()[protectedLabel] == "true":
+		return "protected"
+	case isDefaultRbacObject(kind, object.GetName()):
+		return "default"
+	case o.profile.protects(kind, object.GetName()):
+		return o.profile.name + " profile"
+	case !o.ForceControlPlane && isControlPlaneObject(kind, item, object):
+		return "control-plane"
+	case !o.selectsAge(object.GetCreationTimestamp()):
+		return outsideAgeFilter
+	}
+	return ""
+}
+
+// objectName is namespace/name for namespaced objects, and name otherwise
+func objectName(object metav1.Object) string {
+	if object.GetNamespace() == "" {
+		return object.GetName()
+	}
+	return object.GetNamespace() + "/" + object.GetName()
+}
+
+// deleteObjects deletes every item in list that is selected by opts, in parallel
+func deleteObjects(ctx context.Context, kind purgeKind, namespace string, list runtime.Object, deleteFn deleteFunc, opts Options, logCh chan<- string, errorCh chan<- error) {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to read %s list", kind.name))
+		return
+	}
+
+	var selected []metav1.Object
+	for _, item := range items {
+		object, err := meta.Accessor(item)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to read %s metadata", kind.name))
+			continue
+		}
+
+		// already being deleted, e.g. waiting for finalizers or its dependents
+		if object.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		if reason := opts.keepReason(kind, item, object); reason != "" {
+			// objects outside of the age filter are too many to log
+			if reason != outsideAgeFilter {
+				logCh <- verbose(fmt.Sprintf("Skipping %s %s: %s", reason, kind.name, objectName(object)))
+			}
+			continue
+		}
+
+		// deleting the owner with foreground propagation deletes this too
+		if opts.skipsDependent(kind, object) {
+			continue
+		}
+
+		selected = append(selected, object)
+	}
+	if opts.DryRun {
+		for _, object := range selected {
+			logCh <- fmt.Sprintf("Would delete %s: %s", kind.name, objectName(object))
+		}
+		return
+	}
+	opts.pass.add(kind, len(selected))
+	opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: kind.name, Found: len(selected)})
+
+	waitGroup := sync.WaitGroup{}
+	for _, object := range selected {
+		waitGroup.Add(1)
+
+		object := object
+		go func() {
+			defer waitGroup.Done()
+			name := object.GetName()
+			if err := deleteFn(ctx, name, deletePolicy); err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete %s %s", kind.name, name))
+				opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: kind.name, Failed: 1})
+				return
+			}
+			opts.deleted.add(kind, object)
+			opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: kind.name, Deleted: 1})
+		}()
+	}
+	waitGroup.Wait()
+}
@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sync"
+	"time"
+)
+
+// how often the remaining dependents are checked while waiting for the garbage collector
+const dependentsPollInterval = 2 * time.Second
+
+// dependent is an object that has owners, so it is left to the garbage collector instead of being deleted
+type dependent struct {
+	kind      purgeKind
+	namespace string
+	name      string
+	uid       types.UID
+	owners    []metav1.OwnerReference
+}
+
+// dependentTracker collects the dependents skipped during a run, so they can be verified afterwards
+type dependentTracker struct {
+	mutex      sync.Mutex
+	dependents []dependent
+}
+
+func newDependentTracker() *dependentTracker {
+	return &dependentTracker{}
+}
+
+// skipsDependent reports whether object may be left to the garbage collector, and records it if so.
+// Whether one of its owners is deleted is only known once the pass is done, verifyDependents deletes the others directly.
+func (o Options) skipsDependent(kind purgeKind, object metav1.Object) bool {
+	if o.Exhaustive || o.dependents == nil || len(object.GetOwnerReferences()) == 0 {
+		return false
+	}
+
+	o.dependents.mutex.Lock()
+	defer o.dependents.mutex.Unlock()
+	o.dependents.dependents = append(o.dependents.dependents, dependent{
+		kind:      kind,
+		namespace: object.GetNamespace(),
+		name:      object.GetName(),
+		uid:       object.GetUID(),
+		owners:    object.GetOwnerReferences(),
+	})
+	return true
+}
+
+// verifyDependents deletes the dependents skipped during the run whose owners are gone or weren't deleted although the
+// run would, e.g. because they failed to be, and waits until the garbage collector removed the others.
+// Dependents of owners that are kept on purpose, e.g. protected ones, are kept with them.
+// Dependents that remain after the timeout are deleted, unless one of their owners still exists.
+func verifyDependents(ctx context.Context, c *clients, mapper meta.RESTMapper, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if opts.dependents == nil || len(opts.dependents.dependents) == 0 {
+		return
+	}
+
+	if opts.DryRun {
+		logCh <- fmt.Sprintf("%d dependents would be deleted by the garbage collector, or directly if their owners aren't deleted", len(opts.dependents.dependents))
+		return
+	}
+
+	var remaining []dependent
+	for _, dependent := range opts.dependents.dependents {
+		if opts.deleted.deletesAnyOwner(dependent.owners) {
+			remaining = append(remaining, dependent)
+			continue
+		}
+
+		// dependents of kept objects are kept with them
+		owner, reason, err := keptOwner(ctx, c, mapper, dependent, opts)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get owners of %s %s, keeping it", dependent.kind.name, dependent.name))
+			continue
+		}
+		if owner != nil {
+			logCh <- fmt.Sprintf("Keeping %s %s/%s, its owner %s %s is kept: %s", dependent.kind.name, dependent.namespace, dependent.name, owner.GetKind(), owner.GetName(), reason)
+			continue
+		}
+		logCh <- fmt.Sprintf("Deleting %s: %s/%s, none of its owners is deleted", dependent.kind.name, dependent.namespace, dependent.name)
+		deleteDependent(ctx, c, dependent, opts, errorCh)
+	}
+	if len(remaining) == 0 {
+		return
+	}
+	logCh <- verbose(fmt.Sprintf("Waiting for the garbage collector to delete %d dependents", len(remaining)))
+
+	_ = wait.PollImmediate(dependentsPollInterval, opts.DependentsTimeout, func() (bool, error) {
+		var stillPresent []dependent
+		for _, dependent := range remaining {
+			exists, err := dependentExists(ctx, c, dependent)
+			if err != nil {
+				errorCh <- err
+				continue
+			}
+			if exists {
+				stillPresent = append(stillPresent, dependent)
+			}
+		}
+		remaining = stillPresent
+		return len(remaining) == 0, nil
+	})
+
+	for _, dependent := range remaining {
+		owner, _, err := existingOwner(ctx, c, mapper, dependent.namespace, dependent.owners)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get owner of %s %s", dependent.kind.name, dependent.name))
+			continue
+		}
+		if owner != nil {
+			logCh <- fmt.Sprintf("Keeping %s %s, its owner %s %s still exists", dependent.kind.name, dependent.name, owner.GetKind(), owner.GetName())
+			continue
+		}
+
+		errorCh <- errors.New(fmt.Sprintf("%s %s was not deleted by the garbage collector after %s, deleting it", dependent.kind.name, dependent.name, opts.DependentsTimeout))
+		deleteDependent(ctx, c, dependent, opts, errorCh)
+	}
+}
+
+// keptOwner returns an existing owner of dependent that the run keeps on purpose, and why, or nil if every owner is
+// gone or would be deleted by the run
+func keptOwner(ctx context.Context, c *clients, mapper meta.RESTMapper, dependent dependent, opts Options) (*unstructured.Unstructured, string, error) {
+	for _, ownerReference := range dependent.owners {
+		owner, ownerKind, err := existingOwner(ctx, c, mapper, dependent.namespace, []metav1.OwnerReference{ownerReference})
+		if err != nil {
+			return nil, "", err
+		}
+		if owner == nil {
+			continue
+		}
+		if reason := opts.keepReason(ownerKind, owner, owner); reason != "" {
+			return owner, reason, nil
+		}
+		if !opts.runsKind(ownerKind, owner.GetNamespace()) {
+			return owner, "not purged by the run", nil
+		}
+		if opts.Baseline != "" && opts.baseline.contains(owner) {
+			return owner, "in the baseline", nil
+		}
+	}
+	return nil, "", nil
+}
+
+func deleteDependent(ctx context.Context, c *clients, dependent dependent, opts Options, errorCh chan<- error) {
+	api := c.dynamicClient.Resource(dependent.kind.resource).Namespace(dependent.namespace)
+	precondition := deletePolicy
+	precondition.Preconditions = &metav1.Preconditions{UID: &dependent.uid}
+	if err := api.Delete(ctx, dependent.name, precondition); err != nil {
+		if !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete %s %s", dependent.kind.name, dependent.name))
+		}
+		return
+	}
+	opts.deleted.add(dependent.kind, &metav1.ObjectMeta{Namespace: dependent.namespace, Name: dependent.name, UID: dependent.uid})
+	opts.pass.add(dependent.kind, 1)
+}
+
+// dependentExists reports whether the dependent is still there, an object recreated with the same name doesn't count
+func dependentExists(ctx context.Context, c *clients, dependent dependent) (bool, error) {
+	api := c.dynamicClient.Resource(dependent.kind.resource).Namespace(dependent.namespace)
+	object, err := api.Get(ctx, dependent.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to get %s %s", dependent.kind.name, dependent.name))
+	}
+	return object.GetUID() == dependent.uid, nil
+}
+
+// existingOwner returns an owner in owners that exists and isn't being deleted, and its kind, or nil if there is none
+func existingOwner(ctx context.Context, c *clients, mapper meta.RESTMapper, namespace string, owners []metav1.OwnerReference) (*unstructured.Unstructured, purgeKind, error) {
+	for _, owner := range owners {
+		groupVersion, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return nil, purgeKind{}, err
+		}
+		mapping, err := mapper.RESTMapping(groupVersion.WithKind(owner.Kind).GroupKind(), groupVersion.Version)
+		if err != nil {
+			// the owner's kind is gone, and so is the owner
+			continue
+		}
+
+		kind := purgeKind{mapping.Resource.Resource, mapping.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace}
+		ownerNamespace := ""
+		if kind.namespaced {
+			ownerNamespace = namespace
+		}
+
+		object, err := c.dynamicClient.Resource(mapping.Resource).Namespace(ownerNamespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, purgeKind{}, err
+		}
+		if object.GetUID() == owner.UID && object.GetDeletionTimestamp() == nil {
+			return object, kind, nil
+		}
+	}
+	return nil, purgeKind{}, nil
+}
@@ -1,17 +1,18 @@
 package plugin
 
 import (
-	"fmt"
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"sync"
 )
 
-func deleteEvents(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteEvents(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(eventsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.EventsV1().Events(namespace)
 
@@ -20,17 +21,5 @@ func deleteEvents(clientset *kubernetes.Clientset, namespace string, logCh chan<
 		errorCh <- errors.Wrap(err, "failed to list events")
 		return
 	}
-	for _, event := range events.Items {
-		waitGroup.Add(1)
-
-		name := event.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete event %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, eventsKind, namespace, events, api.Delete, opts, logCh, errorCh)
 }
@@ -0,0 +1,294 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sync"
+	"time"
+)
+
+// how often the pods are checked while draining, and evictions blocked by a PodDisruptionBudget are retried
+const drainPollInterval = 2 * time.Second
+
+var (
+	scaleToZeroPatch = []byte(`{"spec":{"replicas":0}}`)
+	suspendPatch     = []byte(`{"spec":{"suspend":true}}`)
+	orphanPolicy     = metav1.DeletePropagationOrphan
+)
+
+// drainNamespace stops the workloads in namespace before they are deleted: Deployments, StatefulSets and ReplicaSets
+// are scaled to zero, CronJobs are suspended and the pods of DaemonSets are deleted or evicted.
+// It waits until their pods terminated, so they shut down with their own grace period.
+func drainNamespace(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.Gentle {
+		return
+	}
+
+	suspendCronJobs(ctx, clientset, namespace, opts, logCh, errorCh)
+
+	// the workloads whose pods are drained
+	owners := map[types.UID]bool{}
+	scaleDeployments(ctx, clientset, namespace, owners, opts, logCh, errorCh)
+	scaleStatefulSets(ctx, clientset, namespace, owners, opts, logCh, errorCh)
+	scaleReplicaSets(ctx, clientset, namespace, owners, opts, logCh, errorCh)
+	daemonSetPods := stopDaemonSets(ctx, clientset, namespace, owners, opts, logCh, errorCh)
+	if opts.DryRun {
+		return
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list pods in: %s", namespace))
+		return
+	}
+	draining := map[types.UID]string{}
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owners[owner.UID] {
+			draining[pod.UID] = pod.Name
+		}
+	}
+	for _, pod := range daemonSetPods {
+		draining[pod.UID] = pod.Name
+	}
+	if len(draining) == 0 {
+		return
+	}
+
+	waitGroup := sync.WaitGroup{}
+	for _, pod := range daemonSetPods {
+		pod := pod
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			deletePodGently(ctx, clientset, pod, opts, errorCh)
+		}()
+	}
+	waitGroup.Wait()
+
+	logCh <- verbose(fmt.Sprintf("Waiting for %d pods to terminate in: %s", len(draining), namespace))
+	_ = wait.PollImmediate(drainPollInterval, opts.DrainTimeout, func() (bool, error) {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list pods in: %s", namespace))
+			return false, nil
+		}
+		remaining := map[types.UID]string{}
+		for _, pod := range pods.Items {
+			if name, ok := draining[pod.UID]; ok {
+				remaining[pod.UID] = name
+			}
+		}
+		draining = remaining
+		return len(draining) == 0, nil
+	})
+	if len(draining) > 0 {
+		errorCh <- errors.New(fmt.Sprintf("%d pods in %s didn't terminate within %s, deleting them", len(draining), namespace, opts.DrainTimeout))
+	}
+}
+
+// drains reports whether the workload object of kind is stopped before it is deleted
+func (o Options) drains(kind purgeKind, item runtime.Object, object metav1.Object) bool {
+	return o.runsKind(kind, object.GetNamespace()) && object.GetDeletionTimestamp() == nil && o.keepReason(kind, item, object) == ""
+}
+
+func suspendCronJobs(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(cronJobsKind, namespace) {
+		return
+	}
+
+	api := clientset.BatchV1().CronJobs(namespace)
+	cronJobs, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list cronJobs")
+		return
+	}
+	for _, cronJob := range cronJobs.Items {
+		if !opts.drains(cronJobsKind, &cronJob, &cronJob) || (cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend) {
+			continue
+		}
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would suspend cronJob: %s", objectName(&cronJob))
+			continue
+		}
+		if _, err := api.Patch(ctx, cronJob.Name, types.MergePatchType, suspendPatch, metav1.PatchOptions{}); err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to suspend cronJob %s", cronJob.Name))
+		}
+	}
+}
+
+func scaleDeployments(ctx context.Context, clientset *kubernetes.Clientset, namespace string, owners map[types.UID]bool, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(deploymentsKind, namespace) {
+		return
+	}
+
+	api := clientset.AppsV1().Deployments(namespace)
+	deployments, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list deployments")
+		return
+	}
+	for _, deployment := range deployments.Items {
+		if !opts.drains(deploymentsKind, &deployment, &deployment) {
+			continue
+		}
+		owners[deployment.UID] = true
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would scale deployment to 0: %s", objectName(&deployment))
+			continue
+		}
+		if _, err := api.Patch(ctx, deployment.Name, types.MergePatchType, scaleToZeroPatch, metav1.PatchOptions{}); err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to scale deployment %s", deployment.Name))
+		}
+	}
+}
+
+func scaleStatefulSets(ctx context.Context, clientset *kubernetes.Clientset, namespace string, owners map[types.UID]bool, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(statefulSetsKind, namespace) {
+		return
+	}
+
+	api := clientset.AppsV1().StatefulSets(namespace)
+	statefulSets, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list statefulSets")
+		return
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if !opts.drains(statefulSetsKind, &statefulSet, &statefulSet) {
+			continue
+		}
+		owners[statefulSet.UID] = true
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would scale statefulSet to 0: %s", objectName(&statefulSet))
+			continue
+		}
+		if _, err := api.Patch(ctx, statefulSet.Name, types.MergePatchType, scaleToZeroPatch, metav1.PatchOptions{}); err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to scale statefulSet %s", statefulSet.Name))
+		}
+	}
+}
+
+// scaleReplicaSets scales the ReplicaSets without a controller to zero, the ones of scaled Deployments are drained with them
+func scaleReplicaSets(ctx context.Context, clientset *kubernetes.Clientset, namespace string, owners map[types.UID]bool, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(replicaSetsKind, namespace) && len(owners) == 0 {
+		return
+	}
+
+	api := clientset.AppsV1().ReplicaSets(namespace)
+	replicaSets, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list replicaSets")
+		return
+	}
+	for _, replicaSet := range replicaSets.Items {
+		if owner := metav1.GetControllerOf(&replicaSet); owner != nil {
+			if owners[owner.UID] {
+				owners[replicaSet.UID] = true
+			}
+			continue
+		}
+		if !opts.drains(replicaSetsKind, &replicaSet, &replicaSet) {
+			continue
+		}
+		owners[replicaSet.UID] = true
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would scale replicaSet to 0: %s", objectName(&replicaSet))
+			continue
+		}
+		if _, err := api.Patch(ctx, replicaSet.Name, types.MergePatchType, scaleToZeroPatch, metav1.PatchOptions{}); err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to scale replicaSet %s", replicaSet.Name))
+		}
+	}
+}
+
+// stopDaemonSets deletes the DaemonSets but orphans their pods, so the pods can be deleted gently instead of by the garbage collector.
+// It returns the orphaned pods.
+func stopDaemonSets(ctx context.Context, clientset *kubernetes.Clientset, namespace string, owners map[types.UID]bool, opts Options, logCh chan<- string, errorCh chan<- error) []corev1.Pod {
+	if !opts.runsKind(daemonSetsKind, namespace) {
+		return nil
+	}
+
+	api := clientset.AppsV1().DaemonSets(namespace)
+	daemonSets, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list daemonSets")
+		return nil
+	}
+	stopped := map[types.UID]bool{}
+	for _, daemonSet := range daemonSets.Items {
+		if !opts.drains(daemonSetsKind, &daemonSet, &daemonSet) {
+			continue
+		}
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would delete the pods of daemonSet: %s", objectName(&daemonSet))
+			continue
+		}
+		stopped[daemonSet.UID] = true
+	}
+	if len(stopped) == 0 {
+		return nil
+	}
+
+	// the pods have to be listed before their owner references are removed
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list pods in: %s", namespace))
+		return nil
+	}
+	var orphaned []corev1.Pod
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && stopped[owner.UID] {
+			orphaned = append(orphaned, pod)
+		}
+	}
+
+	for _, daemonSet := range daemonSets.Items {
+		if !stopped[daemonSet.UID] {
+			continue
+		}
+		err := api.Delete(ctx, daemonSet.Name, metav1.DeleteOptions{PropagationPolicy: &orphanPolicy})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete daemonSet %s", daemonSet.Name))
+			continue
+		}
+		owners[daemonSet.UID] = true
+		opts.deleted.add(daemonSetsKind, &daemonSet)
+		opts.pass.add(daemonSetsKind, 1)
+	}
+	return orphaned
+}
+
+// deletePodGently deletes pod with its own grace period, or evicts it with Evict, retrying while a PodDisruptionBudget blocks it
+func deletePodGently(ctx context.Context, clientset *kubernetes.Clientset, pod corev1.Pod, opts Options, errorCh chan<- error) {
+	api := clientset.CoreV1().Pods(pod.Namespace)
+	preconditions := metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(pod.UID))}
+	if !opts.Evict {
+		if err := api.Delete(ctx, pod.Name, preconditions); err != nil && !apierrors.IsNotFound(err) {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete pod %s", pod.Name))
+		}
+		return
+	}
+
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &preconditions,
+	}
+	var evictErr error
+	_ = wait.PollImmediate(drainPollInterval, opts.DrainTimeout, func() (bool, error) {
+		evictErr = api.Evict(ctx, eviction)
+		// TooManyRequests means a PodDisruptionBudget doesn't allow the eviction yet
+		return !apierrors.IsTooManyRequests(evictErr), nil
+	})
+	if evictErr != nil && !apierrors.IsNotFound(evictErr) {
+		errorCh <- errors.Wrap(evictErr, fmt.Sprintf("failed to evict pod %s", pod.Name))
+	}
+}
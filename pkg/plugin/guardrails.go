@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// a namespace or ConfigMap with this label set to "true" marks the whole cluster as off limits
+const forbiddenLabel = "purge.kubectl.io/forbidden"
+
+// Guardrails are hard checks run before anything is deleted.
+// Unlike the confirmation prompt, they can only be bypassed with Override.
+type Guardrails struct {
+	// glob patterns matched against the current context name and API server URL
+	ProtectedPatterns []string
+	// if not empty, only clusters whose kube-system namespace has one of these UIDs may be purged
+	AllowedClusterUIDs []string
+	// skip every check, set by --i-know-what-im-doing
+	Override bool
+}
+
+func checkGuardrails(ctx context.Context, guardrails Guardrails, configFlags *genericclioptions.ConfigFlags, config *rest.Config, clientset *kubernetes.Clientset) error {
+	if guardrails.Override {
+		return nil
+	}
+	if err := checkClusterIdentity(ctx, guardrails, configFlags, config, clientset); err != nil {
+		return err
+	}
+	return checkForbiddenMarkers(ctx, clientset)
+}
+
+// checkClusterIdentity refuses protected contexts and servers, and clusters whose UID isn't allowed
+func checkClusterIdentity(ctx context.Context, guardrails Guardrails, configFlags *genericclioptions.ConfigFlags, config *rest.Config, clientset *kubernetes.Clientset) error {
+	if guardrails.Override {
+		return nil
+	}
+
+	contextName, err := currentContextName(configFlags)
+	if err != nil {
+		return err
+	}
+
+	if contextName != "" && util.MatchesAnyGlob(guardrails.ProtectedPatterns, contextName) {
+		return errors.New(fmt.Sprintf("context %s is protected", contextName))
+	}
+	if util.MatchesAnyGlob(guardrails.ProtectedPatterns, config.Host) {
+		return errors.New(fmt.Sprintf("server %s is protected", config.Host))
+	}
+
+	if len(guardrails.AllowedClusterUIDs) > 0 {
+		kubeSystem, err := clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to get kube-system namespace")
+		}
+		if !util.Contains(guardrails.AllowedClusterUIDs, string(kubeSystem.UID)) {
+			return errors.New(fmt.Sprintf("cluster UID %s is not in the list of allowed clusters", kubeSystem.UID))
+		}
+	}
+	return nil
+}
+
+// checkForbiddenMarkers refuses clusters with a namespace or ConfigMap labeled as forbidden
+func checkForbiddenMarkers(ctx context.Context, clientset *kubernetes.Clientset) error {
+	forbiddenSelector := metav1.ListOptions{LabelSelector: forbiddenLabel + "=true"}
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, forbiddenSelector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list forbidden namespaces")
+	}
+	if len(namespaces.Items) > 0 {
+		return errors.New(fmt.Sprintf("namespace %s is labeled %s=true", namespaces.Items[0].Name, forbiddenLabel))
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, forbiddenSelector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list forbidden configMaps")
+	}
+	if len(configMaps.Items) > 0 {
+		marker := configMaps.Items[0]
+		return errors.New(fmt.Sprintf("configMap %s/%s is labeled %s=true", marker.Namespace, marker.Name, forbiddenLabel))
+	}
+
+	return nil
+}
+
+func currentContextName(configFlags *genericclioptions.ConfigFlags) (string, error) {
+	if configFlags.Context != nil && *configFlags.Context != "" {
+		return *configFlags.Context, nil
+	}
+
+	rawConfig, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read kubeconfig")
+	}
+	return rawConfig.CurrentContext, nil
+}
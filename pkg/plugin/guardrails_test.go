@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"golang.org/x/net/context"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	"strings"
+	"testing"
+)
+
+func TestCheckClusterIdentityProtectedPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		context  string
+		host     string
+		override bool
+		wantErr  string
+	}{
+		{name: "no patterns", context: "prod-eu", host: "https://api.prod.example.com"},
+		{name: "protected context", patterns: []string{"prod-*"}, context: "prod-eu", host: "https://127.0.0.1:6443", wantErr: "context prod-eu is protected"},
+		{name: "protected server", patterns: []string{"*.prod.example.com*"}, context: "admin", host: "https://api.prod.example.com:6443", wantErr: "server https://api.prod.example.com:6443 is protected"},
+		{name: "unprotected", patterns: []string{"prod-*", "*.prod.example.com*"}, context: "kind-dev", host: "https://127.0.0.1:6443"},
+		{name: "overridden", patterns: []string{"prod-*"}, context: "prod-eu", host: "https://127.0.0.1:6443", override: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFlags := genericclioptions.NewConfigFlags(false)
+			configFlags.Context = &test.context
+			guardrails := Guardrails{ProtectedPatterns: test.patterns, Override: test.override}
+
+			// without AllowedClusterUIDs the cluster isn't asked for anything
+			err := checkClusterIdentity(context.Background(), guardrails, configFlags, &rest.Config{Host: test.host}, nil)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
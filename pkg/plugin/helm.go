@@ -0,0 +1,380 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"strings"
+	"sync"
+)
+
+const (
+	// Helm 3 stores each revision of a release in a Secret of this type, labeled owner=helm
+	helmReleaseSecretType = "helm.sh/release.v1"
+
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// helmRelease is the part of a decoded Helm release that is needed to find its objects
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Manifest  string `json:"manifest"`
+}
+
+func (r helmRelease) key() string {
+	return r.Namespace + "/" + r.Name
+}
+
+// helmObject is an object that belongs to a Helm release
+type helmObject struct {
+	kind      purgeKind
+	namespace string
+	name      string
+}
+
+func (o helmObject) key() string {
+	return fmt.Sprintf("%s/%s/%s", o.kind.resource.GroupResource(), o.namespace, o.name)
+}
+
+// purgesHelmReleases reports whether the run only purges Helm releases
+func (o Options) purgesHelmReleases() bool {
+	return o.HelmReleasesOnly || len(o.HelmReleases) > 0
+}
+
+// selectsHelmRelease reports whether the release is selected by HelmReleases, as "name" or "namespace/name"
+func (o Options) selectsHelmRelease(release helmRelease) bool {
+	if o.Scope == ScopeNamespaces && !util.Contains(o.Namespaces, release.Namespace) {
+		return false
+	}
+	if len(o.HelmReleases) == 0 {
+		return true
+	}
+	for _, selected := range o.HelmReleases {
+		if selected == release.Name || selected == release.key() {
+			return true
+		}
+	}
+	return false
+}
+
+// scopesHelmObject reports whether object is in scope, a release may reach beyond the namespaces that are purged
+func (o Options) scopesHelmObject(object helmObject) bool {
+	if object.namespace == "" {
+		return o.purgesCluster()
+	}
+	return o.Scope != ScopeNamespaces || util.Contains(o.Namespaces, object.namespace)
+}
+
+// checkHelmReleases rejects the options that only apply to purging whole namespaces, which a Helm release purge doesn't do
+func checkHelmReleases(opts Options) error {
+	if !opts.purgesHelmReleases() {
+		return nil
+	}
+	if opts.Scope == ScopeCrds {
+		return errors.New("--helm-release and --helm-releases-only can't be combined with purging CRDs")
+	}
+	if opts.Converge || opts.Verify {
+		return errors.New("--helm-release and --helm-releases-only can't be combined with --converge or --verify")
+	}
+	if opts.Strategy != "" && opts.Strategy != StrategyContentsFirst {
+		return errors.New(fmt.Sprintf("--helm-release and --helm-releases-only can't be combined with --strategy=%s", opts.Strategy))
+	}
+	if opts.Gentle {
+		return errors.New("--helm-release and --helm-releases-only can't be combined with --gentle")
+	}
+	return nil
+}
+
+// purgeHelmReleases deletes the objects of the selected Helm releases, in every namespace they reach, and then their history.
+// It returns the namespaces the releases reached.
+func purgeHelmReleases(ctx context.Context, c *clients, mapper meta.RESTMapper, opts Options, logCh chan<- string, errorCh chan<- error) ([]string, error) {
+
+	secretsApi := c.clientset.CoreV1().Secrets(metav1.NamespaceAll)
+	secrets, err := secretsApi.List(ctx, metav1.ListOptions{
+		FieldSelector: "type=" + helmReleaseSecretType,
+		LabelSelector: "owner=helm",
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list helm release secrets")
+	}
+
+	releases := map[string]helmRelease{}
+	var history []corev1.Secret
+	for _, secret := range secrets.Items {
+		release, err := decodeHelmRelease(secret)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to decode helm release secret %s/%s", secret.Namespace, secret.Name))
+			continue
+		}
+		if !opts.selectsHelmRelease(release) {
+			continue
+		}
+
+		// every revision is kept, objects of older revisions may still be around
+		history = append(history, secret)
+		if existing, ok := releases[release.key()]; ok {
+			existing.Manifest += "\n---\n" + release.Manifest
+			release = existing
+		}
+		releases[release.key()] = release
+	}
+
+	if len(releases) == 0 {
+		logCh <- "No helm releases selected"
+		return nil, nil
+	}
+
+	objects := map[string]helmObject{}
+	for _, release := range releases {
+		logCh <- fmt.Sprintf("Deleting helm release: %s", release.key())
+
+		manifestObjects, err := helmManifestObjects(release, mapper)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to read manifest of helm release %s", release.key()))
+		}
+		for _, object := range manifestObjects {
+			if opts.scopesHelmObject(object) {
+				objects[object.key()] = object
+			}
+		}
+	}
+
+	annotatedObjects, err := helmAnnotatedObjects(ctx, c, releases, errorCh)
+	if err != nil {
+		errorCh <- err
+	}
+	for _, object := range annotatedObjects {
+		if opts.scopesHelmObject(object) {
+			objects[object.key()] = object
+		}
+	}
+
+	kinds := []purgeKind{secretsKind}
+	seenKinds := map[schema.GroupResource]bool{secretsKind.resource.GroupResource(): true}
+	var namespaces []string
+	seenNamespaces := map[string]bool{}
+	for _, secret := range history {
+		if !seenNamespaces[secret.Namespace] {
+			seenNamespaces[secret.Namespace] = true
+			namespaces = append(namespaces, secret.Namespace)
+		}
+	}
+	for _, object := range objects {
+		if !seenKinds[object.kind.resource.GroupResource()] {
+			seenKinds[object.kind.resource.GroupResource()] = true
+			kinds = append(kinds, object.kind)
+		}
+		if object.namespace != "" && !seenNamespaces[object.namespace] {
+			seenNamespaces[object.namespace] = true
+			namespaces = append(namespaces, object.namespace)
+		}
+	}
+	if err := preflightAccess(ctx, c.clientset, kinds, namespaces, &opts, logCh); err != nil {
+		return nil, err
+	}
+
+	waitGroup := sync.WaitGroup{}
+	for _, object := range objects {
+		waitGroup.Add(1)
+
+		object := object
+		go func() {
+			defer waitGroup.Done()
+			deleteHelmObject(ctx, c, object, opts, logCh, errorCh)
+		}()
+	}
+	waitGroup.Wait()
+
+	// delete the release history last, so a failed purge can be retried
+	for _, secret := range history {
+		if !opts.runsKind(secretsKind, secret.Namespace) {
+			continue
+		}
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would delete helm release secret: %s/%s", secret.Namespace, secret.Name)
+			continue
+		}
+		api := c.clientset.CoreV1().Secrets(secret.Namespace)
+		if err := api.Delete(ctx, secret.Name, deletePolicy); err != nil && !apierrors.IsNotFound(err) {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete helm release secret %s/%s", secret.Namespace, secret.Name))
+		}
+	}
+	return namespaces, nil
+}
+
+// decodeHelmRelease decodes a release Secret, its data is base64 encoded and gzipped JSON
+func decodeHelmRelease(secret corev1.Secret) (helmRelease, error) {
+	release := helmRelease{}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(secret.Data["release"]))
+	if err != nil {
+		return release, errors.Wrap(err, "failed to decode base64")
+	}
+
+	// releases written by old Helm versions aren't gzipped
+	if bytes.HasPrefix(decoded, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return release, errors.Wrap(err, "failed to read gzip")
+		}
+		defer reader.Close()
+
+		decoded, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return release, errors.Wrap(err, "failed to read gzip")
+		}
+	}
+
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return release, errors.Wrap(err, "failed to decode json")
+	}
+	return release, nil
+}
+
+// helmManifestObjects parses the objects from a release's multi-document YAML manifest
+func helmManifestObjects(release helmRelease, mapper meta.RESTMapper) ([]helmObject, error) {
+	var objects []helmObject
+
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(release.Manifest), 4096)
+	for {
+		object := &unstructured.Unstructured{}
+		if err := decoder.Decode(&object.Object); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return objects, err
+		}
+		if len(object.Object) == 0 {
+			continue
+		}
+
+		gvk := object.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			// the kind may not exist anymore, e.g. when its CRD was deleted
+			continue
+		}
+
+		namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+		namespace := ""
+		if namespaced {
+			namespace = object.GetNamespace()
+			if namespace == "" {
+				namespace = release.Namespace
+			}
+		}
+
+		objects = append(objects, helmObject{
+			kind:      purgeKind{mapping.Resource.Resource, mapping.Resource, namespaced},
+			namespace: namespace,
+			name:      object.GetName(),
+		})
+	}
+}
+
+// helmAnnotatedObjects finds the objects annotated as belonging to one of the releases, e.g. ones adopted by Helm.
+// A kind that fails to be listed is reported and skipped.
+func helmAnnotatedObjects(ctx context.Context, c *clients, releases map[string]helmRelease, errorCh chan<- error) ([]helmObject, error) {
+	resourceLists, err := c.clientset.Discovery().ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "failed to discover resources")
+	}
+
+	var objects []helmObject
+	deletable := discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, resourceLists)
+	for _, resourceList := range deletable {
+		groupVersion, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to parse group version %s", resourceList.GroupVersion))
+			continue
+		}
+
+		for _, resource := range resourceList.APIResources {
+			gvr := groupVersion.WithResource(resource.Name)
+			list, err := c.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list %s, its objects annotated with a helm release are kept", gvr.GroupResource()))
+				continue
+			}
+
+			for _, object := range list.Items {
+				annotations := object.GetAnnotations()
+				release := helmRelease{
+					Name:      annotations[helmReleaseNameAnnotation],
+					Namespace: annotations[helmReleaseNamespaceAnnotation],
+				}
+				if _, ok := releases[release.key()]; !ok {
+					continue
+				}
+
+				objects = append(objects, helmObject{
+					kind:      purgeKind{gvr.Resource, gvr, resource.Namespaced},
+					namespace: object.GetNamespace(),
+					name:      object.GetName(),
+				})
+			}
+		}
+	}
+	return objects, nil
+}
+
+func deleteHelmObject(ctx context.Context, c *clients, object helmObject, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(object.kind, object.namespace) {
+		return
+	}
+
+	api := c.dynamicClient.Resource(object.kind.resource).Namespace(object.namespace)
+	existing, err := api.Get(ctx, object.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get %s %s", object.kind.name, object.name))
+		return
+	}
+
+	list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*existing}}
+	deleteFn := func(ctx context.Context, name string, deleteOptions metav1.DeleteOptions) error {
+		return api.Delete(ctx, name, deleteOptions)
+	}
+	deleteObjects(ctx, object.kind, object.namespace, list, deleteFn, opts, logCh, errorCh)
+
+	// the load balancers of deleted Services are waited for, like in a namespace purge
+	if object.kind.resource.GroupResource() != servicesKind.resource.GroupResource() {
+		return
+	}
+	if uid, ok := opts.deleted.uid(servicesKind, object.namespace, object.name); !ok || uid != existing.GetUID() {
+		return
+	}
+	service := corev1.Service{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, &service); err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to convert service %s", objectName(&service)))
+		return
+	}
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		opts.loadBalancers.add([]loadBalancer{{
+			namespace: service.Namespace,
+			name:      service.Name,
+			uid:       service.UID,
+			ingress:   loadBalancerIngress(service),
+		}})
+	}
+}
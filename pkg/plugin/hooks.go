@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"os"
+	"os/exec"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+	"time"
+)
+
+// namespaces declare their own hooks in this annotation, as a YAML list of Hooks
+const hooksAnnotation = "purge.kubectl.io/hooks"
+
+const (
+	// run before anything in the namespace is deleted
+	HookPhasePre = "pre"
+	// run after the namespace was purged
+	HookPhasePost = "post"
+
+	// a failed pre hook keeps the namespace from being purged
+	HookFailurePolicyFail = "Fail"
+	// a failed hook is reported, and the purge goes on
+	HookFailurePolicyIgnore = "Ignore"
+)
+
+// how long a hook may run, if it doesn't set a timeout
+const defaultHookTimeout = 5 * time.Minute
+
+// how often a hook Job is checked while it runs
+const hookPollInterval = 2 * time.Second
+
+// Policy is the policy file, passed with --policy-file
+type Policy struct {
+	Hooks []Hook `json:"hooks,omitempty"`
+}
+
+// Hook runs a local command or a Job before or after a namespace is purged
+type Hook struct {
+	// glob patterns of the namespaces the hook runs for, every namespace if empty. Only used in the policy file.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// HookPhasePre or HookPhasePost
+	Phase string `json:"phase"`
+	// local command, run with PURGE_NAMESPACE, PURGE_CONTEXT and PURGE_PHASE set. Only allowed in the policy file.
+	Command []string `json:"command,omitempty"`
+	// Job run in the namespace
+	Job *batchv1.Job `json:"job,omitempty"`
+	// how long the hook may run, 5m by default
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// HookFailurePolicyFail by default
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+func (h Hook) String() string {
+	if h.Job != nil {
+		name := h.Job.Name
+		if name == "" {
+			name = h.Job.GenerateName
+		}
+		return fmt.Sprintf("%s hook job %s", h.Phase, name)
+	}
+	return fmt.Sprintf("%s hook %s", h.Phase, strings.Join(h.Command, " "))
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.Timeout.Duration > 0 {
+		return h.Timeout.Duration
+	}
+	return defaultHookTimeout
+}
+
+func (h Hook) validate(fromAnnotation bool) error {
+	if h.Phase != HookPhasePre && h.Phase != HookPhasePost {
+		return errors.New(fmt.Sprintf("hook phase must be %s or %s, not %q", HookPhasePre, HookPhasePost, h.Phase))
+	}
+	if (len(h.Command) == 0) == (h.Job == nil) {
+		return errors.New("a hook needs either a command or a job")
+	}
+	// anyone who can annotate a namespace could run commands on the machine of whoever purges otherwise
+	if fromAnnotation && len(h.Command) > 0 {
+		return errors.New("hooks in namespace annotations can only run jobs")
+	}
+	if h.FailurePolicy != "" && h.FailurePolicy != HookFailurePolicyFail && h.FailurePolicy != HookFailurePolicyIgnore {
+		return errors.New(fmt.Sprintf("hook failure policy must be %s or %s, not %q", HookFailurePolicyFail, HookFailurePolicyIgnore, h.FailurePolicy))
+	}
+	return nil
+}
+
+// LoadPolicy reads and validates the policy file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy file")
+	}
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to decode policy file %s", file))
+	}
+	for _, hook := range policy.Hooks {
+		if err := hook.validate(false); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid hook in %s", file))
+		}
+	}
+	return policy, nil
+}
+
+// hookRunner runs the hooks of each namespace once per run, also when the purge is repeated
+type hookRunner struct {
+	policy      *Policy
+	contextName string
+
+	mutex sync.Mutex
+	// the result of the hooks per namespace and phase
+	ran map[string]error
+}
+
+func newHookRunner(policy *Policy, contextName string) *hookRunner {
+	return &hookRunner{policy: policy, contextName: contextName, ran: map[string]error{}}
+}
+
+// runHooks runs the hooks of phase in hooks for namespace, and returns an error if one failed with HookFailurePolicyFail.
+// Once they ran, their result is returned again without running them.
+func (r *hookRunner) runHooks(ctx context.Context, c *clients, namespace string, hooks []Hook, phase string, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	if r == nil {
+		return nil
+	}
+
+	key := namespace + "/" + phase
+	r.mutex.Lock()
+	err, ran := r.ran[key]
+	r.mutex.Unlock()
+	if ran {
+		return err
+	}
+
+	err = r.runPhase(ctx, c, namespace, hooks, phase, opts, logCh, errorCh)
+	r.mutex.Lock()
+	r.ran[key] = err
+	r.mutex.Unlock()
+	return err
+}
+
+func (r *hookRunner) runPhase(ctx context.Context, c *clients, namespace string, hooks []Hook, phase string, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	for _, hook := range hooks {
+		if hook.Phase != phase {
+			continue
+		}
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would run %s in: %s", hook, namespace)
+			continue
+		}
+
+		logCh <- fmt.Sprintf("Running %s in: %s", hook, namespace)
+		hookCtx, cancel := context.WithTimeout(ctx, hook.timeout())
+		var err error
+		if hook.Job != nil {
+			err = runJobHook(hookCtx, c, namespace, hook)
+		} else {
+			err = r.runCommandHook(hookCtx, namespace, hook)
+		}
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		err = errors.Wrap(err, fmt.Sprintf("%s failed in %s", hook, namespace))
+		if hook.FailurePolicy == HookFailurePolicyIgnore {
+			errorCh <- err
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// namespaceHooks returns the hooks of the policy file that match namespace, followed by the ones in its annotation
+func (r *hookRunner) namespaceHooks(ctx context.Context, c *clients, namespace string) ([]Hook, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	var hooks []Hook
+	if r.policy != nil {
+		for _, hook := range r.policy.Hooks {
+			if len(hook.Namespaces) == 0 || util.MatchesAnyGlob(hook.Namespaces, namespace) {
+				hooks = append(hooks, hook)
+			}
+		}
+	}
+
+	current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get namespace: %s", namespace))
+	}
+	annotation, ok := current.Annotations[hooksAnnotation]
+	if !ok {
+		return hooks, nil
+	}
+
+	var annotated []Hook
+	if err := yaml.UnmarshalStrict([]byte(annotation), &annotated); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to decode %s of namespace %s", hooksAnnotation, namespace))
+	}
+	for _, hook := range annotated {
+		if err := hook.validate(true); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid hook in %s of namespace %s", hooksAnnotation, namespace))
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func (r *hookRunner) runCommandHook(ctx context.Context, namespace string, hook Hook) error {
+	command := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	command.Env = append(os.Environ(),
+		"PURGE_NAMESPACE="+namespace,
+		"PURGE_CONTEXT="+r.contextName,
+		"PURGE_PHASE="+hook.Phase,
+	)
+	output, err := command.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New(fmt.Sprintf("timed out after %s", hook.timeout()))
+	}
+	if err != nil {
+		return errors.Wrap(err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runJobHook creates the Job of hook in namespace, and waits until it completed
+func runJobHook(ctx context.Context, c *clients, namespace string, hook Hook) error {
+	job := hook.Job.DeepCopy()
+	job.Namespace = namespace
+	job.ResourceVersion = ""
+	if job.Name == "" && job.GenerateName == "" {
+		job.GenerateName = "purge-hook-"
+	}
+
+	api := c.clientset.BatchV1().Jobs(namespace)
+	created, err := api.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to create job")
+	}
+
+	var jobErr error
+	err = wait.PollImmediateUntil(hookPollInterval, func() (bool, error) {
+		current, err := api.Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, condition := range current.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				return true, nil
+			case batchv1.JobFailed:
+				jobErr = errors.New(fmt.Sprintf("job %s failed: %s", created.Name, condition.Message))
+				return true, nil
+			}
+		}
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return errors.New(fmt.Sprintf("job %s didn't complete within %s", created.Name, hook.timeout()))
+	}
+	if jobErr != nil {
+		// kept to be looked into
+		return jobErr
+	}
+
+	// nothing purges the namespace after a post hook, its Job would be left behind
+	if hook.Phase == HookPhasePost {
+		if err := api.Delete(ctx, created.Name, deletePolicy); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, fmt.Sprintf("failed to delete job %s", created.Name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sync"
+	"time"
+)
+
+const (
+	// how long a namespace lives after its creation, e.g. "72h"
+	ttlAnnotation = "purge.kubectl.io/ttl"
+	// when a namespace expires, as an RFC 3339 timestamp
+	expiresAtAnnotation = "purge.kubectl.io/expires-at"
+)
+
+// JanitorOptions configure the long-running janitor
+type JanitorOptions struct {
+	// how often every namespace is checked for expiry
+	Interval time.Duration
+	// where the leader election Lease is stored
+	LeaseNamespace string
+	LeaseName      string
+	// unique name of this replica, e.g. the pod name
+	Identity string
+}
+
+// RunJanitor watches namespaces and purges every namespace whose TTL annotation has expired.
+// Only the replica holding the leader election Lease purges; RunJanitor returns once ctx is done, the Lease is lost or the janitor fails.
+func RunJanitor(ctx context.Context, configFlags *genericclioptions.ConfigFlags, opts Options, janitorOpts JanitorOptions, logCh chan<- string, errorCh chan<- error) error {
+	defer close(logCh)
+	defer close(errorCh)
+
+	config, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read kubeconfig")
+	}
+
+	c, err := newClients(config)
+	if err != nil {
+		return err
+	}
+
+	// the forbidden markers take cluster-wide lists, so they are only checked at startup
+	if err := checkGuardrails(ctx, opts.Guardrails, configFlags, config, c.clientset); err != nil {
+		return errors.Wrap(err, "refusing to run the janitor, pass --i-know-what-im-doing to override")
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: janitorOpts.LeaseNamespace,
+			Name:      janitorOpts.LeaseName,
+		},
+		Client: c.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: janitorOpts.Identity,
+		},
+	}
+
+	// the janitor gives up the Lease when it fails, so another replica can take over
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Run doesn't wait for OnStartedLeading, which sends on logCh and errorCh until it returns
+	loopWaitGroup := sync.WaitGroup{}
+	loopMutex := sync.Mutex{}
+	stopped := false
+	loopErrCh := make(chan error, 1)
+
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				loopMutex.Lock()
+				if stopped {
+					loopMutex.Unlock()
+					return
+				}
+				loopWaitGroup.Add(1)
+				loopMutex.Unlock()
+				defer loopWaitGroup.Done()
+
+				logCh <- fmt.Sprintf("Started leading as %s", janitorOpts.Identity)
+				if err := runJanitorLoop(ctx, c, configFlags, config, opts, janitorOpts, logCh, errorCh); err != nil {
+					loopErrCh <- err
+					cancel()
+				}
+			},
+			OnStoppedLeading: func() {
+				logCh <- fmt.Sprintf("Stopped leading as %s", janitorOpts.Identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != janitorOpts.Identity {
+					logCh <- fmt.Sprintf("Current leader: %s", identity)
+				}
+			},
+		},
+	})
+
+	loopMutex.Lock()
+	stopped = true
+	loopMutex.Unlock()
+	loopWaitGroup.Wait()
+
+	select {
+	case err := <-loopErrCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+func runJanitorLoop(ctx context.Context, c *clients, configFlags *genericclioptions.ConfigFlags, config *rest.Config, opts Options, janitorOpts JanitorOptions, logCh chan<- string, errorCh chan<- error) error {
+	opts, err := prepareRun(ctx, c.clientset, configFlags, opts, logCh)
+	if err != nil {
+		return err
+	}
+
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return errors.Wrap(err, "failed to create REST mapper")
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(c.clientset, janitorOpts.Interval)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+
+	// namespaces currently being purged, so they aren't purged twice
+	purging := map[string]bool{}
+	purgingMutex := &sync.Mutex{}
+	purgeWaitGroup := sync.WaitGroup{}
+	defer purgeWaitGroup.Wait()
+
+	purgeIfExpired := func(namespace *corev1.Namespace) {
+		name := namespace.Name
+		if util.Contains(systemNamespaces, name) || opts.profile.protectsNamespace(name) || namespace.Labels[protectedLabel] == "true" || namespace.DeletionTimestamp != nil {
+			return
+		}
+
+		expiresAt, ok, err := namespaceExpiry(namespace)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("invalid expiry on namespace: %s", name))
+			return
+		}
+		if !ok || time.Now().Before(expiresAt) {
+			return
+		}
+
+		purgingMutex.Lock()
+		defer purgingMutex.Unlock()
+		if purging[name] {
+			return
+		}
+
+		purging[name] = true
+		purgeWaitGroup.Add(1)
+		go func() {
+			defer purgeWaitGroup.Done()
+			defer func() {
+				purgingMutex.Lock()
+				delete(purging, name)
+				purgingMutex.Unlock()
+			}()
+
+			// the kubeconfig or the cluster behind it may have changed since the janitor started
+			if err := checkClusterIdentity(ctx, opts.Guardrails, configFlags, config, c.clientset); err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("refusing to purge expired namespace: %s", name))
+				return
+			}
+
+			logCh <- fmt.Sprintf("Deleting expired namespace: %s (expired at %s)", name, expiresAt.Format(time.RFC3339))
+			// every purge tracks its own objects, so concurrent purges don't wait for each other's
+			purgeOpts := opts
+			purgeOpts.dependents = newDependentTracker()
+			purgeOpts.deleted = newDeletedTracker()
+			purgeOpts.loadBalancers = newLoadBalancerTracker()
+			purgeOpts.pass = newPassTracker()
+			purgeNamespaceWithStrategy(c, name, purgeOpts, logCh, errorCh)
+			verifyDependents(ctx, c, mapper, purgeOpts, logCh, errorCh)
+			if err := waitForLoadBalancers(ctx, c.clientset, purgeOpts.loadBalancers.list(), purgeOpts, logCh, errorCh); err != nil {
+				errorCh <- err
+			}
+			reportRetainedVolumes(ctx, c, []string{name}, purgeOpts, logCh, errorCh)
+		}()
+	}
+
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			purgeIfExpired(obj.(*corev1.Namespace))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			purgeIfExpired(newObj.(*corev1.Namespace))
+		},
+	})
+
+	informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), namespaceInformer.Informer().HasSynced) {
+		return errors.New("failed to sync namespace cache")
+	}
+	logCh <- fmt.Sprintf("Watching namespaces, checking for expired ones every %s", janitorOpts.Interval)
+
+	// expiry is time based, so no watch event fires when a TTL passes
+	ticker := time.NewTicker(janitorOpts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			namespaces, err := namespaceInformer.Lister().List(labels.Everything())
+			if err != nil {
+				errorCh <- errors.Wrap(err, "failed to list namespaces")
+				continue
+			}
+			for _, namespace := range namespaces {
+				purgeIfExpired(namespace)
+			}
+		}
+	}
+}
+
+// namespaceExpiry reads the expiry time from the namespace's annotations, ok is false if it has none
+func namespaceExpiry(namespace *corev1.Namespace) (expiresAt time.Time, ok bool, err error) {
+	if value, found := namespace.Annotations[expiresAtAnnotation]; found {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, false, errors.Wrap(err, fmt.Sprintf("failed to parse %s annotation", expiresAtAnnotation))
+		}
+		return expiresAt, true, nil
+	}
+
+	if value, found := namespace.Annotations[ttlAnnotation]; found {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, false, errors.Wrap(err, fmt.Sprintf("failed to parse %s annotation", ttlAnnotation))
+		}
+		return namespace.CreationTimestamp.Add(ttl), true, nil
+	}
+
+	return time.Time{}, false, nil
+}
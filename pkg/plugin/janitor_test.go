@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestNamespaceExpiry(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        time.Time
+		wantOk      bool
+		wantErr     bool
+	}{
+		{name: "no annotations"},
+		{name: "unrelated annotation", annotations: map[string]string{"owner": "ci"}},
+		{name: "ttl counts from creation", annotations: map[string]string{ttlAnnotation: "72h"}, want: created.Add(72 * time.Hour), wantOk: true},
+		{name: "ttl with minutes", annotations: map[string]string{ttlAnnotation: "1h30m"}, want: created.Add(90 * time.Minute), wantOk: true},
+		{name: "expires at", annotations: map[string]string{expiresAtAnnotation: "2021-07-01T00:00:00Z"}, want: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), wantOk: true},
+		{name: "expires at with offset", annotations: map[string]string{expiresAtAnnotation: "2021-07-01T02:00:00+02:00"}, want: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), wantOk: true},
+		{name: "expires at wins over ttl", annotations: map[string]string{ttlAnnotation: "1h", expiresAtAnnotation: "2021-07-01T00:00:00Z"}, want: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), wantOk: true},
+		{name: "days aren't a duration", annotations: map[string]string{ttlAnnotation: "3d"}, wantErr: true},
+		{name: "invalid timestamp", annotations: map[string]string{expiresAtAnnotation: "2021-07-01"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:              "preview",
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations:       test.annotations,
+			}}
+
+			got, ok, err := namespaceExpiry(namespace)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if ok != test.wantOk || !got.Equal(test.want) {
+				t.Errorf("namespaceExpiry() = %s, %v, want %s, %v", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/restmapper"
+	"strings"
+)
+
+// kindFilter is the set of kinds selected by Only and Skip
+type kindFilter struct {
+	only map[schema.GroupResource]bool
+	skip map[schema.GroupResource]bool
+}
+
+// selects reports whether kind takes part in the run, a nil filter selects every kind
+func (f *kindFilter) selects(kind purgeKind) bool {
+	if f == nil {
+		return true
+	}
+	groupResource := kind.resource.GroupResource()
+	if len(f.only) > 0 && !f.only[groupResource] {
+		return false
+	}
+	return !f.skip[groupResource]
+}
+
+// selectsKind reports whether kind takes part in the run, given KeepStorage, Only and Skip
+func (o Options) selectsKind(kind purgeKind) bool {
+	return !o.keepsStorage(kind) && o.kindFilter.selects(kind)
+}
+
+// resolveKindFilter resolves the resource names of Only and Skip like kubectl does,
+// e.g. "deploy", "cm", "crd", "certificates.cert-manager.io" or the "all" category
+func resolveKindFilter(configFlags *genericclioptions.ConfigFlags, opts Options) (*kindFilter, error) {
+	if len(opts.Only) == 0 && len(opts.Skip) == 0 {
+		return nil, nil
+	}
+
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create REST mapper")
+	}
+	discoveryClient, err := configFlags.ToDiscoveryClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create discovery client")
+	}
+	return newKindFilter(mapper, restmapper.NewDiscoveryCategoryExpander(discoveryClient), opts.Only, opts.Skip)
+}
+
+// newKindFilter resolves the names of only and skip through categories, and then mapper
+func newKindFilter(mapper meta.RESTMapper, categories restmapper.CategoryExpander, only []string, skip []string) (*kindFilter, error) {
+	resolve := func(names []string) (map[schema.GroupResource]bool, error) {
+		resources := map[schema.GroupResource]bool{}
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			if groupResources, ok := categories.Expand(name); ok {
+				for _, groupResource := range groupResources {
+					resources[groupResource] = true
+				}
+				continue
+			}
+
+			// a name can match several groups, e.g. events in the core and events.k8s.io groups
+			gvrs, err := mapper.ResourcesFor(schema.ParseGroupResource(name).WithVersion(""))
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("unknown resource type %s", name))
+			}
+			for _, gvr := range gvrs {
+				resources[gvr.GroupResource()] = true
+			}
+		}
+		return resources, nil
+	}
+
+	onlyResources, err := resolve(only)
+	if err != nil {
+		return nil, err
+	}
+	skipResources, err := resolve(skip)
+	if err != nil {
+		return nil, err
+	}
+	return &kindFilter{only: onlyResources, skip: skipResources}, nil
+}
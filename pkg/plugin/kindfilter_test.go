@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/restmapper"
+	"testing"
+)
+
+var (
+	coreEventsKind   = purgeKind{"event", schema.GroupVersionResource{Version: "v1", Resource: "events"}, true}
+	certificatesKind = purgeKind{"certificates.cert-manager.io", schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}, true}
+)
+
+// testKindMapper knows a few built-in kinds, the events of both groups and a custom resource
+func testKindMapper() (meta.RESTMapper, restmapper.CategoryExpander) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		{Version: "v1", Kind: "Secret"},
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "Event"},
+		{Group: "events.k8s.io", Version: "v1", Kind: "Event"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	categories := restmapper.SimpleCategoryExpander{Expansions: map[string][]schema.GroupResource{
+		"all": {podsKind.resource.GroupResource(), deploymentsKind.resource.GroupResource()},
+	}}
+	return mapper, categories
+}
+
+func TestKindFilter(t *testing.T) {
+	allKinds := []purgeKind{configMapsKind, secretsKind, podsKind, coreEventsKind, eventsKind, deploymentsKind, certificatesKind}
+	tests := []struct {
+		name     string
+		only     []string
+		skip     []string
+		selected []purgeKind
+	}{
+		{name: "no filter", selected: allKinds},
+		{name: "plural", only: []string{"deployments"}, selected: []purgeKind{deploymentsKind}},
+		{name: "singular", only: []string{"deployment"}, selected: []purgeKind{deploymentsKind}},
+		{name: "case and spaces", only: []string{" ConfigMaps "}, selected: []purgeKind{configMapsKind}},
+		{name: "empty names are ignored", only: []string{"secrets", ""}, selected: []purgeKind{secretsKind}},
+		{name: "group qualified", only: []string{"certificates.cert-manager.io"}, selected: []purgeKind{certificatesKind}},
+		{name: "category", only: []string{"all"}, selected: []purgeKind{podsKind, deploymentsKind}},
+		{name: "a name in several groups", only: []string{"events"}, selected: []purgeKind{coreEventsKind, eventsKind}},
+		{name: "skip", skip: []string{"secrets", "events"}, selected: []purgeKind{configMapsKind, podsKind, deploymentsKind, certificatesKind}},
+		{name: "skip wins over only", only: []string{"all"}, skip: []string{"pods"}, selected: []purgeKind{deploymentsKind}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapper, categories := testKindMapper()
+			filter, err := newKindFilter(mapper, categories, test.only, test.skip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, kind := range allKinds {
+				want := false
+				for _, selected := range test.selected {
+					want = want || selected == kind
+				}
+				if got := filter.selects(kind); got != want {
+					t.Errorf("selects(%s) = %v, want %v", kind.resource.GroupResource(), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKindFilterUnknownResource(t *testing.T) {
+	mapper, categories := testKindMapper()
+	if _, err := newKindFilter(mapper, categories, []string{"widgets"}, nil); err == nil {
+		t.Error("expected an unknown resource type to be refused")
+	}
+	if _, err := newKindFilter(mapper, categories, nil, []string{"widgets"}); err == nil {
+		t.Error("expected an unknown resource type to be refused in skip too")
+	}
+}
+
+func TestNilKindFilterSelectsEverything(t *testing.T) {
+	var filter *kindFilter
+	if !filter.selects(crdsKind) || !filter.selects(podsKind) {
+		t.Error("expected a nil filter to select every kind")
+	}
+}
+
+func TestSelectsKindKeepsStorage(t *testing.T) {
+	opts := Options{KeepStorage: true}
+	if opts.selectsKind(persistentVolumeClaimsKind) || opts.selectsKind(persistentVolumesKind) {
+		t.Error("expected claims and volumes to be kept with KeepStorage")
+	}
+	if !opts.selectsKind(configMapsKind) {
+		t.Error("expected configMaps to be selected with KeepStorage")
+	}
+}
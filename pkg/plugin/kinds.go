@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// purgeKind is a resource type handled by one of the delete helpers
+type purgeKind struct {
+	// used in messages and progress, e.g. "configMap"
+	name       string
+	resource   schema.GroupVersionResource
+	namespaced bool
+}
+
+var (
+	configMapsKind             = purgeKind{"configMap", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, true}
+	endpointsKind              = purgeKind{"endpoint", schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, true}
+	persistentVolumeClaimsKind = purgeKind{"persistentVolumeClaim", schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, true}
+	persistentVolumesKind      = purgeKind{"persistentVolume", schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}, false}
+	secretsKind                = purgeKind{"secret", schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, true}
+	podsKind                   = purgeKind{"pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, true}
+	servicesKind               = purgeKind{"service", schema.GroupVersionResource{Version: "v1", Resource: "services"}, true}
+	serviceAccountsKind        = purgeKind{"serviceAccount", schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}, true}
+	resourceQuotasKind         = purgeKind{"resourceQuota", schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}, true}
+	limitRangesKind            = purgeKind{"limitRange", schema.GroupVersionResource{Version: "v1", Resource: "limitranges"}, true}
+	endpointSlicesKind         = purgeKind{"endpointSlice", schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}, true}
+	deploymentsKind            = purgeKind{"deployment", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, true}
+	daemonSetsKind             = purgeKind{"daemonSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, true}
+	statefulSetsKind           = purgeKind{"statefulSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, true}
+	replicaSetsKind            = purgeKind{"replicaSet", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, true}
+	cronJobsKind               = purgeKind{"cronJob", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, true}
+	jobsKind                   = purgeKind{"job", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, true}
+	eventsKind                 = purgeKind{"event", schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}, true}
+	ingressesKind              = purgeKind{"ingress", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, true}
+	networkPoliciesKind        = purgeKind{"networkPolicy", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}, true}
+	ingressClassesKind         = purgeKind{"ingressClass", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"}, false}
+	podSecurityPoliciesKind    = purgeKind{"podSecurityPolicy", schema.GroupVersionResource{Group: "policy", Version: "v1beta1", Resource: "podsecuritypolicies"}, false}
+	podDisruptionBudgetsKind   = purgeKind{"podDisruptionBudget", schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}, true}
+	rolesKind                  = purgeKind{"role", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}, true}
+	roleBindingsKind           = purgeKind{"roleBinding", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}, true}
+	clusterRolesKind           = purgeKind{"clusterRole", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}, false}
+	clusterRoleBindingsKind    = purgeKind{"clusterRoleBinding", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}, false}
+	crdsKind                   = purgeKind{"crd", schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, false}
+	namespacesKind             = purgeKind{"namespace", schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, false}
+)
+
+// customResourceKind is the kind of the custom resources defined by crd
+func customResourceKind(crd apixv1.CustomResourceDefinition) purgeKind {
+	version := ""
+	for _, crdVersion := range crd.Spec.Versions {
+		if crdVersion.Storage {
+			version = crdVersion.Name
+		}
+	}
+
+	return purgeKind{
+		name:       crd.Name,
+		resource:   schema.GroupVersionResource{Group: crd.Spec.Group, Version: version, Resource: crd.Spec.Names.Plural},
+		namespaced: crd.Spec.Scope == apixv1.NamespaceScoped,
+	}
+}
+
+// purgeKinds are all the kinds RunPlugin deletes, besides namespaces and custom resources
+var purgeKinds = []purgeKind{
+	configMapsKind,
+	endpointsKind,
+	persistentVolumeClaimsKind,
+	persistentVolumesKind,
+	secretsKind,
+	podsKind,
+	servicesKind,
+	serviceAccountsKind,
+	resourceQuotasKind,
+	limitRangesKind,
+	endpointSlicesKind,
+	deploymentsKind,
+	daemonSetsKind,
+	statefulSetsKind,
+	replicaSetsKind,
+	cronJobsKind,
+	jobsKind,
+	eventsKind,
+	ingressesKind,
+	networkPoliciesKind,
+	ingressClassesKind,
+	podSecurityPoliciesKind,
+	podDisruptionBudgetsKind,
+	rolesKind,
+	roleBindingsKind,
+	clusterRolesKind,
+	clusterRoleBindingsKind,
+	crdsKind,
+}
+
+// enabledKinds returns the kinds a run with these options deletes
+func (o Options) enabledKinds() []purgeKind {
+	var kinds []purgeKind
+	for _, kind := range purgeKinds {
+		if o.selectsKind(kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// runsKind reports whether kind takes part in the run in namespace, "" for cluster-scoped kinds
+func (o Options) runsKind(kind purgeKind, namespace string) bool {
+	if !o.selectsKind(kind) {
+		return false
+	}
+	return o.access == nil || o.access.allows(kind, namespace)
+}
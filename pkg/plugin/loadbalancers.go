@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// set on LoadBalancer Services by the cloud controller, and removed once the cloud load balancer is gone
+const loadBalancerCleanupFinalizer = "service.kubernetes.io/load-balancer-cleanup"
+
+// how often the LoadBalancer Services are checked while the cloud cleans up, a variable so tests can shorten it
+var loadBalancerPollInterval = 5 * time.Second
+
+// loadBalancer is a deleted LoadBalancer Service whose cloud cleanup is waited for
+type loadBalancer struct {
+	namespace string
+	name      string
+	uid       types.UID
+	// the addresses of the cloud load balancer, to find it if it leaks
+	ingress []string
+}
+
+// loadBalancerTracker collects the deleted LoadBalancer Services of a run, to wait for their cloud cleanup at the end
+type loadBalancerTracker struct {
+	mutex   sync.Mutex
+	deleted []loadBalancer
+}
+
+func newLoadBalancerTracker() *loadBalancerTracker {
+	return &loadBalancerTracker{}
+}
+
+func (t *loadBalancerTracker) add(loadBalancers []loadBalancer) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.deleted = append(t.deleted, loadBalancers...)
+}
+
+func (t *loadBalancerTracker) list() []loadBalancer {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]loadBalancer{}, t.deleted...)
+}
+
+// deleteLoadBalancers deletes the LoadBalancer Services in namespace ahead of everything else in it,
+// so the cloud controller has the rest of the run to clean up the cloud load balancers. It returns the deleted Services.
+func deleteLoadBalancers(ctx context.Context, clientset kubernetes.Interface, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) []loadBalancer {
+	if !opts.runsKind(servicesKind, namespace) {
+		return nil
+	}
+
+	api := clientset.CoreV1().Services(namespace)
+	services, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list services in: %s", namespace))
+		return nil
+	}
+
+	var selected []corev1.Service
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.DeletionTimestamp != nil {
+			continue
+		}
+		if reason := opts.keepReason(servicesKind, &service, &service); reason != "" {
+			// objects outside of the age filter are too many to log
+			if reason != outsideAgeFilter {
+				logCh <- verbose(fmt.Sprintf("Skipping %s %s: %s", reason, servicesKind.name, objectName(&service)))
+			}
+			continue
+		}
+		if opts.DryRun {
+			logCh <- fmt.Sprintf("Would delete load balancer service: %s", objectName(&service))
+			continue
+		}
+		selected = append(selected, service)
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	opts.pass.add(servicesKind, len(selected))
+	opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: servicesKind.name, Found: len(selected)})
+
+	var deleted []loadBalancer
+	for _, service := range selected {
+		logCh <- fmt.Sprintf("Deleting load balancer service: %s", objectName(&service))
+		if err := api.Delete(ctx, service.Name, deletePolicy); err != nil && !apierrors.IsNotFound(err) {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete service %s", service.Name))
+			opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: servicesKind.name, Failed: 1})
+			continue
+		}
+		opts.deleted.add(servicesKind, &service)
+		opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: servicesKind.name, Deleted: 1})
+		deleted = append(deleted, loadBalancer{
+			namespace: service.Namespace,
+			name:      service.Name,
+			uid:       service.UID,
+			ingress:   loadBalancerIngress(service),
+		})
+	}
+	return deleted
+}
+
+// waitForLoadBalancers waits up to LoadBalancerTimeout for the cloud controller to remove the cleanup finalizer of the
+// deleted Services, and returns an error listing the ones that still have it as leaked
+func waitForLoadBalancers(ctx context.Context, clientset kubernetes.Interface, loadBalancers []loadBalancer, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	if len(loadBalancers) == 0 {
+		return nil
+	}
+
+	remaining := loadBalancers
+	var pending []loadBalancer
+	lastPending := -1
+	_ = wait.PollImmediate(loadBalancerPollInterval, opts.LoadBalancerTimeout, func() (bool, error) {
+		var stillCleaning []loadBalancer
+		pending = nil
+		for _, loadBalancer := range remaining {
+			service, err := clientset.CoreV1().Services(loadBalancer.namespace).Get(ctx, loadBalancer.name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get service %s", loadBalancer.name))
+				stillCleaning = append(stillCleaning, loadBalancer)
+				continue
+			}
+			// a Service recreated with the same name has its own load balancer
+			if service.UID != loadBalancer.uid {
+				continue
+			}
+			stillCleaning = append(stillCleaning, loadBalancer)
+			if util.Contains(service.Finalizers, loadBalancerCleanupFinalizer) {
+				pending = append(pending, loadBalancer)
+			}
+		}
+
+		if len(pending) > 0 && len(pending) != lastPending {
+			logCh <- verbose(fmt.Sprintf("Waiting for the cloud to clean up %d of %d load balancers", len(pending), len(loadBalancers)))
+		}
+		lastPending = len(pending)
+		remaining = stillCleaning
+		return len(remaining) == 0, nil
+	})
+
+	if len(pending) > 0 {
+		return leakedLoadBalancersError(pending, opts.LoadBalancerTimeout)
+	}
+	logCh <- fmt.Sprintf("The cloud cleaned up %d load balancers", len(loadBalancers))
+	return nil
+}
+
+// leakedLoadBalancersError lists the load balancers whose cleanup finalizer remained, the cloud resources may have leaked
+func leakedLoadBalancersError(leaked []loadBalancer, timeout time.Duration) error {
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAMESPACE\tSERVICE\tLOAD BALANCER")
+	for _, loadBalancer := range leaked {
+		ingress := strings.Join(loadBalancer.ingress, ",")
+		if ingress == "" {
+			ingress = "<unknown>"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", loadBalancer.namespace, loadBalancer.name, ingress)
+	}
+	_ = writer.Flush()
+	return errors.New(fmt.Sprintf("%d cloud load balancers may have leaked, %s is still present after %s, check them in the cloud console\n%s",
+		len(leaked), loadBalancerCleanupFinalizer, timeout, strings.TrimRight(buffer.String(), "\n")))
+}
+
+func loadBalancerIngress(service corev1.Service) []string {
+	var ingress []string
+	for _, entry := range service.Status.LoadBalancer.Ingress {
+		if entry.Hostname != "" {
+			ingress = append(ingress, entry.Hostname)
+		} else if entry.IP != "" {
+			ingress = append(ingress, entry.IP)
+		}
+	}
+	return ingress
+}
@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadBalancerService(namespace string, name string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  namespace,
+			Name:       name,
+			UID:        types.UID("uid-" + name),
+			Finalizers: []string{loadBalancerCleanupFinalizer},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}},
+			},
+		},
+	}
+}
+
+// fakeCloud makes deleting a Service only mark it as terminating, like the API server does while the cleanup
+// finalizer is present, and removes the finalizer once cleanUp is called
+type fakeCloud struct {
+	clientset *fake.Clientset
+}
+
+func newFakeCloud(objects ...runtime.Object) *fakeCloud {
+	cloud := &fakeCloud{clientset: fake.NewSimpleClientset(objects...)}
+	cloud.clientset.PrependReactor("delete", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(k8stesting.DeleteAction)
+		object, err := cloud.clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("services"), deleteAction.GetNamespace(), deleteAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		service := object.(*corev1.Service).DeepCopy()
+		now := metav1.Now()
+		service.DeletionTimestamp = &now
+		return true, nil, cloud.clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("services"), service, service.Namespace)
+	})
+	return cloud
+}
+
+// cleanUp removes the cleanup finalizer, and with it the Service
+func (f *fakeCloud) cleanUp(namespace string, name string) error {
+	return f.clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("services"), namespace, name)
+}
+
+func collectLogs() (chan string, chan error, func() ([]string, []error)) {
+	logCh := make(chan string, 100)
+	errorCh := make(chan error, 100)
+	return logCh, errorCh, func() ([]string, []error) {
+		close(logCh)
+		close(errorCh)
+		var logs []string
+		for msg := range logCh {
+			logs = append(logs, msg)
+		}
+		var errs []error
+		for err := range errorCh {
+			errs = append(errs, err)
+		}
+		return logs, errs
+	}
+}
+
+func TestDeleteLoadBalancersOnlyDeletesLoadBalancerServices(t *testing.T) {
+	clusterIP := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "internal"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+	}
+	cloud := newFakeCloud(loadBalancerService("dev", "web"), clusterIP)
+	logCh, errorCh, collect := collectLogs()
+
+	deleted := deleteLoadBalancers(context.Background(), cloud.clientset, "dev", Options{}, logCh, errorCh)
+	_, errs := collect()
+
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(deleted) != 1 || deleted[0].name != "web" || deleted[0].uid != "uid-web" {
+		t.Fatalf("expected only web to be deleted, got %+v", deleted)
+	}
+	if len(deleted[0].ingress) != 1 || deleted[0].ingress[0] != "203.0.113.10" {
+		t.Errorf("expected the ingress address to be recorded, got %v", deleted[0].ingress)
+	}
+
+	service, err := cloud.clientset.CoreV1().Services("dev").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil || service.DeletionTimestamp == nil {
+		t.Errorf("expected web to be terminating, got %v, %v", service, err)
+	}
+	if _, err := cloud.clientset.CoreV1().Services("dev").Get(context.Background(), "internal", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected internal to be kept, got %v", err)
+	}
+}
+
+func TestDeleteLoadBalancersReportsProgress(t *testing.T) {
+	cloud := newFakeCloud(loadBalancerService("dev", "web"), loadBalancerService("dev", "api"))
+	logCh, errorCh, collect := collectLogs()
+	progressCh := make(chan ProgressEvent, 10)
+
+	deleteLoadBalancers(context.Background(), cloud.clientset, "dev", Options{Progress: progressCh}, logCh, errorCh)
+	close(progressCh)
+	collect()
+
+	found, deleted := 0, 0
+	for event := range progressCh {
+		if event.Namespace != "dev" || event.Kind != servicesKind.name {
+			t.Errorf("unexpected progress event %+v", event)
+		}
+		found += event.Found
+		deleted += event.Deleted
+	}
+	if found != 2 || deleted != 2 {
+		t.Errorf("expected 2 services found and deleted, got %d found and %d deleted", found, deleted)
+	}
+}
+
+func TestDeleteLoadBalancersDryRun(t *testing.T) {
+	cloud := newFakeCloud(loadBalancerService("dev", "web"))
+	logCh, errorCh, collect := collectLogs()
+
+	deleted := deleteLoadBalancers(context.Background(), cloud.clientset, "dev", Options{DryRun: true}, logCh, errorCh)
+	logs, _ := collect()
+
+	if len(deleted) != 0 {
+		t.Errorf("expected nothing to be deleted in a dry run, got %+v", deleted)
+	}
+	if len(logs) != 1 || logs[0] != "Would delete load balancer service: dev/web" {
+		t.Errorf("expected the service to be listed once, got %v", logs)
+	}
+	if service, err := cloud.clientset.CoreV1().Services("dev").Get(context.Background(), "web", metav1.GetOptions{}); err != nil || service.DeletionTimestamp != nil {
+		t.Errorf("expected web to be kept, got %v, %v", service, err)
+	}
+}
+
+func TestWaitForLoadBalancersFinalizerCleared(t *testing.T) {
+	defer func(interval time.Duration) { loadBalancerPollInterval = interval }(loadBalancerPollInterval)
+	loadBalancerPollInterval = 10 * time.Millisecond
+
+	cloud := newFakeCloud(loadBalancerService("dev", "web"), loadBalancerService("staging", "api"))
+	logCh, errorCh, collect := collectLogs()
+	opts := Options{LoadBalancerTimeout: 5 * time.Second}
+
+	var deleted []loadBalancer
+	for _, namespace := range []string{"dev", "staging"} {
+		deleted = append(deleted, deleteLoadBalancers(context.Background(), cloud.clientset, namespace, opts, logCh, errorCh)...)
+	}
+
+	// the cloud controller cleans up while the purge waits
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = cloud.cleanUp("dev", "web")
+		_ = cloud.cleanUp("staging", "api")
+	}()
+
+	err := waitForLoadBalancers(context.Background(), cloud.clientset, deleted, opts, logCh, errorCh)
+	logs, errs := collect()
+
+	if err != nil {
+		t.Fatalf("expected no leaked load balancers, got %v", err)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if !strings.Contains(strings.Join(logs, "\n"), "The cloud cleaned up 2 load balancers") {
+		t.Errorf("expected the cleanup to be logged, got %v", logs)
+	}
+}
+
+func TestWaitForLoadBalancersReportsLeaked(t *testing.T) {
+	defer func(interval time.Duration) { loadBalancerPollInterval = interval }(loadBalancerPollInterval)
+	loadBalancerPollInterval = 10 * time.Millisecond
+
+	cloud := newFakeCloud(loadBalancerService("dev", "web"), loadBalancerService("dev", "cleaned"))
+	logCh, errorCh, collect := collectLogs()
+	opts := Options{LoadBalancerTimeout: 100 * time.Millisecond}
+
+	deleted := deleteLoadBalancers(context.Background(), cloud.clientset, "dev", opts, logCh, errorCh)
+	if err := cloud.cleanUp("dev", "cleaned"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := waitForLoadBalancers(context.Background(), cloud.clientset, deleted, opts, logCh, errorCh)
+	_, errs := collect()
+
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if err == nil {
+		t.Fatal("expected the load balancer of web to be reported as leaked")
+	}
+	message := err.Error()
+	if !strings.Contains(message, "1 cloud load balancers may have leaked") {
+		t.Errorf("expected one leaked load balancer, got %q", message)
+	}
+	if !strings.Contains(message, "dev") || !strings.Contains(message, "web") || !strings.Contains(message, "203.0.113.10") {
+		t.Errorf("expected the leaked service and its address in the report, got %q", message)
+	}
+	if strings.Contains(message, "cleaned") {
+		t.Errorf("expected the cleaned up load balancer to be left out, got %q", message)
+	}
+}
+
+func TestWaitForLoadBalancersIgnoresRecreatedService(t *testing.T) {
+	defer func(interval time.Duration) { loadBalancerPollInterval = interval }(loadBalancerPollInterval)
+	loadBalancerPollInterval = 10 * time.Millisecond
+
+	// a Service recreated with the same name has its own load balancer
+	recreated := loadBalancerService("dev", "web")
+	recreated.UID = "uid-recreated"
+	cloud := newFakeCloud(recreated)
+	logCh, errorCh, collect := collectLogs()
+	opts := Options{LoadBalancerTimeout: 100 * time.Millisecond}
+
+	deleted := []loadBalancer{{namespace: "dev", name: "web", uid: "uid-web"}}
+	err := waitForLoadBalancers(context.Background(), cloud.clientset, deleted, opts, logCh, errorCh)
+	collect()
+
+	if err != nil {
+		t.Fatalf("expected the recreated service not to count as leaked, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+	"sort"
+)
+
+const (
+	ManifestModeJanitor = "janitor"
+	ManifestModeCronJob = "cronjob"
+
+	manifestName = "kubectl-purge"
+)
+
+// ManifestOptions configure the in-cluster deployment created by RenderManifests and InstallManifests
+type ManifestOptions struct {
+	Namespace string
+	Image     string
+	// ManifestModeJanitor runs the janitor as a Deployment, ManifestModeCronJob runs a full purge on Schedule
+	Mode     string
+	Schedule string
+	// extra arguments for the container, e.g. --older-than=24h
+	Args []string
+}
+
+// RenderManifests writes the manifests to run kubectl-purge in-cluster as multi-document YAML
+func RenderManifests(configFlags *genericclioptions.ConfigFlags, opts Options, manifestOpts ManifestOptions, out io.Writer) error {
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	objects, err := buildManifests(ctx, configFlags, opts, manifestOpts)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		manifest, err := yaml.Marshal(object)
+		if err != nil {
+			return errors.Wrap(err, "failed to render manifest")
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", manifest); err != nil {
+			return errors.Wrap(err, "failed to write manifest")
+		}
+	}
+	return nil
+}
+
+// InstallManifests server-side applies the manifests to run kubectl-purge in-cluster
+func InstallManifests(configFlags *genericclioptions.ConfigFlags, opts Options, manifestOpts ManifestOptions, logCh chan<- string) error {
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	objects, err := buildManifests(ctx, configFlags, opts, manifestOpts)
+	if err != nil {
+		return err
+	}
+
+	config, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read kubeconfig")
+	}
+	c, err := newClients(config)
+	if err != nil {
+		return err
+	}
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return errors.Wrap(err, "failed to create REST mapper")
+	}
+
+	for _, object := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert manifest")
+		}
+		manifest := &unstructured.Unstructured{Object: content}
+
+		gvk := manifest.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to find resource for %s", gvk.Kind))
+		}
+
+		data, err := manifest.MarshalJSON()
+		if err != nil {
+			return errors.Wrap(err, "failed to encode manifest")
+		}
+
+		api := c.dynamicClient.Resource(mapping.Resource)
+		force := true
+		patchOptions := metav1.PatchOptions{FieldManager: manifestName, Force: &force}
+		if manifest.GetNamespace() != "" {
+			_, err = api.Namespace(manifest.GetNamespace()).Patch(ctx, manifest.GetName(), types.ApplyPatchType, data, patchOptions)
+		} else {
+			_, err = api.Patch(ctx, manifest.GetName(), types.ApplyPatchType, data, patchOptions)
+		}
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to apply %s %s", gvk.Kind, manifest.GetName()))
+		}
+		logCh <- fmt.Sprintf("Applied %s: %s", gvk.Kind, manifest.GetName())
+	}
+	return nil
+}
+
+func buildManifests(ctx context.Context, configFlags *genericclioptions.ConfigFlags, opts Options, manifestOpts ManifestOptions) ([]runtime.Object, error) {
+	if manifestOpts.Image == "" {
+		return nil, errors.New("an image is required")
+	}
+	if manifestOpts.Mode != ManifestModeJanitor && manifestOpts.Mode != ManifestModeCronJob {
+		return nil, errors.New(fmt.Sprintf("unknown mode %s, expected %s or %s", manifestOpts.Mode, ManifestModeJanitor, ManifestModeCronJob))
+	}
+
+	config, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read kubeconfig")
+	}
+	c, err := newClients(config)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.kindFilter, err = resolveKindFilter(configFlags, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// custom resources can only be deleted if the ClusterRole names their CRDs
+	crds, err := c.apixClient.CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list crds")
+	}
+	customResources := map[string][]string{}
+	for _, crd := range crds.Items {
+		if (manifestOpts.Mode == ManifestModeJanitor && crd.Spec.Scope != apixv1.NamespaceScoped) || !opts.selectsKind(customResourceKind(crd)) {
+			continue
+		}
+		customResources[crd.Spec.Group] = append(customResources[crd.Spec.Group], crd.Spec.Names.Plural)
+	}
+
+	// every generated object is labeled as protected, so a purge doesn't delete itself
+	objectMeta := func(name string, namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name": manifestName,
+				protectedLabel:           "true",
+			},
+		}
+	}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: objectMeta(manifestOpts.Namespace, ""),
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: objectMeta(manifestName, manifestOpts.Namespace),
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: objectMeta(manifestName, ""),
+			Rules:      purgePolicyRules(opts, manifestOpts.Mode, customResources),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: objectMeta(manifestName, ""),
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     manifestName,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      manifestName,
+				Namespace: manifestOpts.Namespace,
+			}},
+		},
+	}
+
+	podSpec := corev1.PodSpec{
+		ServiceAccountName: manifestName,
+		Containers: []corev1.Container{{
+			Name:  manifestName,
+			Image: manifestOpts.Image,
+		}},
+	}
+
+	switch manifestOpts.Mode {
+	case ManifestModeJanitor:
+		// the Lease is only needed in our own namespace
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: objectMeta(manifestName, manifestOpts.Namespace),
+				Rules: []rbacv1.PolicyRule{{
+					APIGroups: []string{coordinationv1.GroupName},
+					Resources: []string{"leases"},
+					Verbs:     []string{"get", "create", "update"},
+				}},
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: objectMeta(manifestName, manifestOpts.Namespace),
+				RoleRef: rbacv1.RoleRef{
+					APIGroup: rbacv1.GroupName,
+					Kind:     "Role",
+					Name:     manifestName,
+				},
+				Subjects: []rbacv1.Subject{{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      manifestName,
+					Namespace: manifestOpts.Namespace,
+				}},
+			},
+		)
+
+		podSpec.Containers[0].Args = append([]string{"janitor"}, manifestOpts.Args...)
+		replicas := int32(1)
+		objects = append(objects, &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: objectMeta(manifestName, manifestOpts.Namespace),
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app.kubernetes.io/name": manifestName},
+				},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app.kubernetes.io/name": manifestName},
+					},
+					Spec: podSpec,
+				},
+			},
+		})
+	case ManifestModeCronJob:
+		podSpec.Containers[0].Args = append([]string{"cluster", "--yes"}, manifestOpts.Args...)
+		podSpec.RestartPolicy = corev1.RestartPolicyNever
+		objects = append(objects, &batchv1.CronJob{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+			ObjectMeta: objectMeta(manifestName, manifestOpts.Namespace),
+			Spec: batchv1.CronJobSpec{
+				Schedule:          manifestOpts.Schedule,
+				ConcurrencyPolicy: batchv1.ForbidConcurrent,
+				JobTemplate: batchv1.JobTemplateSpec{
+					Spec: batchv1.JobSpec{
+						Template: corev1.PodTemplateSpec{Spec: podSpec},
+					},
+				},
+			},
+		})
+	}
+
+	return objects, nil
+}
+
+// purgePolicyRules returns the least RBAC rules a purge with these options needs.
+// The janitor only purges namespaces, so it doesn't need any cluster-scoped kinds.
+func purgePolicyRules(opts Options, mode string, customResources map[string][]string) []rbacv1.PolicyRule {
+	// get finds the owners of dependents and the objects of Helm releases
+	purgeVerbs := []string{"get", "list", "delete"}
+
+	resourcesByGroup := map[string][]string{}
+	for _, kind := range opts.enabledKinds() {
+		if mode == ManifestModeJanitor && !kind.namespaced {
+			continue
+		}
+		resourcesByGroup[kind.resource.Group] = append(resourcesByGroup[kind.resource.Group], kind.resource.Resource)
+	}
+	for group, resources := range customResources {
+		resourcesByGroup[group] = append(resourcesByGroup[group], resources...)
+	}
+
+	rules := []rbacv1.PolicyRule{{
+		APIGroups: []string{corev1.GroupName},
+		Resources: []string{"namespaces"},
+		Verbs:     []string{"get", "list", "watch", "delete"},
+	}}
+	rules = append(rules, groupedPolicyRules(resourcesByGroup, purgeVerbs)...)
+
+	if mode == ManifestModeJanitor {
+		// custom resources are found through their CRDs, which the janitor never deletes
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{crdsKind.resource.Group},
+			Resources: []string{crdsKind.resource.Resource},
+			Verbs:     []string{"list"},
+		})
+	}
+
+	if opts.KeepStorage {
+		// the volumes left behind are reported, and with RetainVolumes switched to Retain and made available again
+		verbs := []string{"list"}
+		if opts.RetainVolumes {
+			verbs = append(verbs, "patch")
+		}
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"persistentvolumes"},
+			Verbs:     verbs,
+		}, rbacv1.PolicyRule{
+			// namespaces holding claims are kept
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"persistentvolumeclaims"},
+			Verbs:     []string{"list"},
+		})
+	}
+
+	if !opts.Guardrails.Override {
+		// the guardrails look for ConfigMaps labeled as forbidden in every namespace, whatever kinds are purged
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"list"},
+		})
+	}
+
+	// the hooks in namespace annotations run Jobs
+	rules = append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{batchv1.GroupName},
+		Resources: []string{"jobs"},
+		Verbs:     []string{"create", "get"},
+	})
+
+	if opts.selectsKind(podsKind) {
+		// pods stuck on unavailable nodes are force-deleted
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"pods"},
+			Verbs:     []string{"patch"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"nodes"},
+			Verbs:     []string{"list"},
+		})
+	}
+
+	if opts.Gentle {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{appsv1.GroupName},
+			Resources: []string{"deployments", "statefulsets", "replicasets"},
+			Verbs:     []string{"list", "patch"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{batchv1.GroupName},
+			Resources: []string{"cronjobs"},
+			Verbs:     []string{"list", "patch"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"pods"},
+			Verbs:     []string{"list", "delete"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"pods/eviction"},
+			Verbs:     []string{"create"},
+		})
+	}
+
+	if opts.Strategy == StrategyNamespaceDelete || opts.Strategy == StrategyHybrid {
+		// namespaces stuck terminating are cleared of the finalizers of the purged kinds, and then of their own
+		rules = append(rules, groupedPolicyRules(resourcesByGroup, []string{"patch"})...)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"namespaces/finalize"},
+			Verbs:     []string{"update"},
+		})
+	}
+
+	if opts.Profile == "" || opts.Profile == ProfileAuto {
+		// the distribution is detected from the node labels
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"nodes"},
+			Verbs:     []string{"list"},
+		})
+	}
+
+	if opts.NamespaceActivity {
+		// the newest object in a namespace is looked for among the namespaced kinds a purge can delete, whatever Only and Skip select
+		activityByGroup := map[string][]string{}
+		for _, kind := range purgeKinds {
+			if kind.namespaced {
+				activityByGroup[kind.resource.Group] = append(activityByGroup[kind.resource.Group], kind.resource.Resource)
+			}
+		}
+		for group, resources := range customResources {
+			activityByGroup[group] = append(activityByGroup[group], resources...)
+		}
+		rules = append(rules, groupedPolicyRules(activityByGroup, []string{"list"})...)
+	}
+	return rules
+}
+
+// groupedPolicyRules returns a rule per API group granting verbs on its resources, sorted by group
+func groupedPolicyRules(resourcesByGroup map[string][]string, verbs []string) []rbacv1.PolicyRule {
+	groups := make([]string, 0, len(resourcesByGroup))
+	for group := range resourcesByGroup {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var rules []rbacv1.PolicyRule
+	for _, group := range groups {
+		resources := resourcesByGroup[group]
+		sort.Strings(resources)
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{group},
+			Resources: resources,
+			Verbs:     verbs,
+		})
+	}
+	return rules
+}
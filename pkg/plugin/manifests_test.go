@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+)
+
+// grants reports whether one of rules allows verb on resource in group
+func grants(rules []rbacv1.PolicyRule, group string, resource string, verb string) bool {
+	for _, rule := range rules {
+		if matchesRule(rule.APIGroups, group) && matchesRule(rule.Resources, resource) && matchesRule(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func kindSet(kinds ...purgeKind) map[schema.GroupResource]bool {
+	set := map[schema.GroupResource]bool{}
+	for _, kind := range kinds {
+		set[kind.resource.GroupResource()] = true
+	}
+	return set
+}
+
+func TestPurgePolicyRules(t *testing.T) {
+	customResources := map[string][]string{"cert-manager.io": {"certificates"}}
+	type grant struct {
+		group    string
+		resource string
+		verb     string
+	}
+	tests := []struct {
+		name    string
+		opts    Options
+		mode    string
+		granted []grant
+		denied  []grant
+	}{
+		{
+			name: "janitor",
+			mode: ManifestModeJanitor,
+			granted: []grant{
+				{"", "namespaces", "watch"},
+				{"", "namespaces", "delete"},
+				{"apps", "deployments", "delete"},
+				{"cert-manager.io", "certificates", "delete"},
+				{"apiextensions.k8s.io", "customresourcedefinitions", "list"},
+				{"batch", "jobs", "create"},
+				{"", "configmaps", "list"},
+				{"", "nodes", "list"},
+			},
+			denied: []grant{
+				{"rbac.authorization.k8s.io", "clusterroles", "delete"},
+				{"apiextensions.k8s.io", "customresourcedefinitions", "delete"},
+				{"", "persistentvolumes", "list"},
+				{"", "namespaces/finalize", "update"},
+				{"apps", "deployments", "patch"},
+			},
+		},
+		{
+			name: "cronjob purges cluster-scoped kinds too",
+			mode: ManifestModeCronJob,
+			granted: []grant{
+				{"rbac.authorization.k8s.io", "clusterroles", "delete"},
+				{"apiextensions.k8s.io", "customresourcedefinitions", "delete"},
+			},
+		},
+		{
+			name:    "only and skip",
+			mode:    ManifestModeJanitor,
+			opts:    Options{kindFilter: &kindFilter{skip: kindSet(secretsKind)}},
+			granted: []grant{{"", "configmaps", "delete"}},
+			denied:  []grant{{"", "secrets", "delete"}},
+		},
+		{
+			name:    "keep storage",
+			mode:    ManifestModeJanitor,
+			opts:    Options{KeepStorage: true},
+			granted: []grant{{"", "persistentvolumes", "list"}, {"", "persistentvolumeclaims", "list"}},
+			denied:  []grant{{"", "persistentvolumeclaims", "delete"}, {"", "persistentvolumes", "patch"}},
+		},
+		{
+			name:    "retain volumes",
+			mode:    ManifestModeJanitor,
+			opts:    Options{KeepStorage: true, RetainVolumes: true},
+			granted: []grant{{"", "persistentvolumes", "patch"}},
+		},
+		{
+			name:   "guardrails overridden",
+			mode:   ManifestModeJanitor,
+			opts:   Options{Guardrails: Guardrails{Override: true}, kindFilter: &kindFilter{skip: kindSet(configMapsKind)}},
+			denied: []grant{{"", "configmaps", "list"}},
+		},
+		{
+			name:    "guardrails list configMaps even if they aren't purged",
+			mode:    ManifestModeJanitor,
+			opts:    Options{kindFilter: &kindFilter{skip: kindSet(configMapsKind)}},
+			granted: []grant{{"", "configmaps", "list"}},
+			denied:  []grant{{"", "configmaps", "delete"}},
+		},
+		{
+			name:    "namespace-delete strategy",
+			mode:    ManifestModeJanitor,
+			opts:    Options{Strategy: StrategyNamespaceDelete},
+			granted: []grant{{"", "namespaces/finalize", "update"}, {"apps", "deployments", "patch"}, {"cert-manager.io", "certificates", "patch"}},
+		},
+		{
+			name:    "gentle",
+			mode:    ManifestModeJanitor,
+			opts:    Options{Gentle: true},
+			granted: []grant{{"apps", "deployments", "patch"}, {"batch", "cronjobs", "patch"}, {"", "pods/eviction", "create"}},
+		},
+		{
+			name:   "fixed profile",
+			mode:   ManifestModeJanitor,
+			opts:   Options{Profile: "kind", kindFilter: &kindFilter{skip: kindSet(podsKind)}},
+			denied: []grant{{"", "nodes", "list"}},
+		},
+		{
+			name:    "namespace activity",
+			mode:    ManifestModeJanitor,
+			opts:    Options{NamespaceActivity: true, kindFilter: &kindFilter{only: kindSet(configMapsKind)}},
+			granted: []grant{{"apps", "deployments", "list"}, {"cert-manager.io", "certificates", "list"}},
+			denied:  []grant{{"apps", "deployments", "delete"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := purgePolicyRules(test.opts, test.mode, customResources)
+			for _, g := range test.granted {
+				if !grants(rules, g.group, g.resource, g.verb) {
+					t.Errorf("expected %s on %s.%s to be granted", g.verb, g.resource, g.group)
+				}
+			}
+			for _, g := range test.denied {
+				if grants(rules, g.group, g.resource, g.verb) {
+					t.Errorf("expected %s on %s.%s not to be granted", g.verb, g.resource, g.group)
+				}
+			}
+		})
+	}
+}
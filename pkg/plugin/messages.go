@@ -0,0 +1,19 @@
+package plugin
+
+import "strings"
+
+// messages on logCh starting with verbosePrefix are details, e.g. skipped objects and polling, printed from verbosity 1 on
+const verbosePrefix = "\x00"
+
+// verbose marks msg as a detail
+func verbose(msg string) string {
+	return verbosePrefix + msg
+}
+
+// MessageLevel returns the verbosity a message from logCh is printed at, and its text
+func MessageLevel(msg string) (int, string) {
+	if strings.HasPrefix(msg, verbosePrefix) {
+		return 1, strings.TrimPrefix(msg, verbosePrefix)
+	}
+	return 0, msg
+}
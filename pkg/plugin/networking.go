@@ -1,17 +1,18 @@
 package plugin
 
 import (
-	"fmt"
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"sync"
 )
 
-func deleteIngresses(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteIngresses(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(ingressesKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.NetworkingV1().Ingresses(namespace)
 
@@ -20,25 +21,16 @@ func deleteIngresses(clientset *kubernetes.Clientset, namespace string, logCh ch
 		errorCh <- errors.Wrap(err, "failed to list ingresses")
 		return
 	}
-	for _, ingress := range ingresses.Items {
-		waitGroup.Add(1)
+	deleteObjects(ctx, ingressesKind, namespace, ingresses, api.Delete, opts, logCh, errorCh)
+}
 
-		name := ingress.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete ingress %s", name))
-			}
-		}()
+func deleteNetworkPolicies(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(networkPoliciesKind, namespace) {
+		return
 	}
-	waitGroup.Wait()
-}
 
-func deleteNetworkPolicies(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.NetworkingV1().NetworkPolicies(namespace)
 
@@ -47,26 +39,17 @@ func deleteNetworkPolicies(clientset *kubernetes.Clientset, namespace string, lo
 		errorCh <- errors.Wrap(err, "failed to list networkPolicies")
 		return
 	}
-	for _, networkPolicy := range networkPolicies.Items {
-		waitGroup.Add(1)
-
-		name := networkPolicy.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete networkPolicy %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, networkPoliciesKind, namespace, networkPolicies, api.Delete, opts, logCh, errorCh)
 }
 
 // this should be fine, as there are no IngressClasses by default
-func deleteIngressClasses(clientset *kubernetes.Clientset, logCh chan<- string, errorCh chan<- error) {
+func deleteIngressClasses(clientset *kubernetes.Clientset, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(ingressClassesKind, "") {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.NetworkingV1().IngressClasses()
 
@@ -75,17 +58,5 @@ func deleteIngressClasses(clientset *kubernetes.Clientset, logCh chan<- string,
 		errorCh <- errors.Wrap(err, "failed to list ingressClasses")
 		return
 	}
-	for _, ingressClass := range ingressClasses.Items {
-		waitGroup.Add(1)
-
-		name := ingressClass.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete ingressClass %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, ingressClassesKind, "", ingressClasses, api.Delete, opts, logCh, errorCh)
 }
@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"net/http"
+	"os/user"
+	"time"
+)
+
+const (
+	// a report sent when a purge starts
+	ReportEventStarted = "started"
+	// a report sent when a purge finished, successfully or not
+	ReportEventFinished = "finished"
+)
+
+const (
+	// the HMAC-SHA256 of the payload, keyed with the notify secret, as "sha256=<hex>"
+	signatureHeader = "X-Purge-Signature"
+	// the Event of the report
+	eventHeader = "X-Purge-Event"
+)
+
+// the first retry waits this long, every further one twice as long, a variable so tests can shorten it
+var notifyRetryDelay = time.Second
+
+// NotifyOptions controls where reports are sent
+type NotifyOptions struct {
+	// every report is POSTed to each of these
+	URLs []string
+	// key of the signature header, no signature is sent if it is empty
+	Secret string
+	// how often a failed POST is retried
+	Retries int
+	// how long a single POST may take
+	Timeout time.Duration
+}
+
+// Report describes a purge, it is sent as JSON to the notify URLs
+type Report struct {
+	Event     string          `json:"event"`
+	Context   string          `json:"context"`
+	Server    string          `json:"server"`
+	User      string          `json:"user"`
+	KubeUser  string          `json:"kubeUser,omitempty"`
+	Selection ReportSelection `json:"selection"`
+	// set once the purge finished
+	Counts   *ReportCounts `json:"counts,omitempty"`
+	Failures []string      `json:"failures,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// in seconds
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// ReportSelection is what the purge was asked to delete
+type ReportSelection struct {
+	Scope        string   `json:"scope"`
+	Namespaces   []string `json:"namespaces,omitempty"`
+	CrdGroups    []string `json:"crdGroups,omitempty"`
+	HelmReleases []string `json:"helmReleases,omitempty"`
+	Only         []string `json:"only,omitempty"`
+	Skip         []string `json:"skip,omitempty"`
+	OlderThan    string   `json:"olderThan,omitempty"`
+	NewerThan    string   `json:"newerThan,omitempty"`
+	Baseline     string   `json:"baseline,omitempty"`
+	Strategy     string   `json:"strategy,omitempty"`
+	DryRun       bool     `json:"dryRun"`
+}
+
+// ReportCounts are the objects found, deleted and failed to delete, in total and per kind
+type ReportCounts struct {
+	Found   int                  `json:"found"`
+	Deleted int                  `json:"deleted"`
+	Failed  int                  `json:"failed"`
+	Kinds   map[string]KindCount `json:"kinds,omitempty"`
+}
+
+// KindCount are the objects of one kind found, deleted and failed to delete
+type KindCount struct {
+	Found   int `json:"found"`
+	Deleted int `json:"deleted"`
+	Failed  int `json:"failed"`
+}
+
+// NewReport describes a purge with opts of the cluster in configFlags
+func NewReport(configFlags *genericclioptions.ConfigFlags, opts Options, event string, startedAt time.Time) Report {
+	report := Report{
+		Event: event,
+		Selection: ReportSelection{
+			Scope:        opts.Scope,
+			Namespaces:   opts.Namespaces,
+			CrdGroups:    opts.CrdGroups,
+			HelmReleases: opts.HelmReleases,
+			Only:         opts.Only,
+			Skip:         opts.Skip,
+			Baseline:     opts.Baseline,
+			Strategy:     opts.Strategy,
+			DryRun:       opts.DryRun,
+		},
+		StartedAt: startedAt,
+	}
+	if opts.OlderThan > 0 {
+		report.Selection.OlderThan = opts.OlderThan.String()
+	}
+	if opts.NewerThan > 0 {
+		report.Selection.NewerThan = opts.NewerThan.String()
+	}
+
+	if current, err := user.Current(); err == nil {
+		report.User = current.Username
+	}
+	report.Context, _ = currentContextName(configFlags)
+	if config, err := configFlags.ToRESTConfig(); err == nil {
+		report.Server = config.Host
+	}
+	if rawConfig, err := configFlags.ToRawKubeConfigLoader().RawConfig(); err == nil {
+		if kubeContext, ok := rawConfig.Contexts[report.Context]; ok {
+			report.KubeUser = kubeContext.AuthInfo
+		}
+	}
+	return report
+}
+
+// Notify POSTs report to every URL, and returns an error listing the URLs that failed after retrying
+func Notify(ctx context.Context, opts NotifyOptions, report Report) error {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode report")
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	var failed []string
+	for _, url := range opts.URLs {
+		if err := postWithRetries(ctx, client, url, payload, report.Event, opts); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("failed to notify %d of %d URLs: %s", len(failed), len(opts.URLs), failed))
+	}
+	return nil
+}
+
+func postWithRetries(ctx context.Context, client *http.Client, url string, payload []byte, event string, opts NotifyOptions) error {
+	delay := notifyRetryDelay
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Wrap(ctx.Err(), url)
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		var retry bool
+		retry, err = post(ctx, client, url, payload, event, opts.Secret)
+		if err == nil || !retry {
+			break
+		}
+	}
+	return errors.Wrap(err, url)
+}
+
+// post sends payload to url once, and reports whether a failure is worth retrying
+func post(ctx context.Context, client *http.Client, url string, payload []byte, event string, secret string) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(eventHeader, event)
+	if secret != "" {
+		request.Header.Set(signatureHeader, Signature(secret, payload))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	err = errors.New(fmt.Sprintf("status %s", response.Status))
+	// other client errors won't go away by sending the same payload again
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Signature is the value of the signature header for payload, so receivers can verify it with the same secret
+func Signature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
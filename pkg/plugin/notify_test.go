@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook is a local receiver that answers with the given statuses in turn, and 200 once they are used up
+type webhook struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	server   *httptest.Server
+}
+
+func newWebhook(t *testing.T, statuses ...int) *webhook {
+	w := &webhook{statuses: statuses}
+	w.server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+
+		w.mutex.Lock()
+		w.requests = append(w.requests, request)
+		w.bodies = append(w.bodies, body)
+		status := http.StatusOK
+		if len(w.statuses) > 0 {
+			status, w.statuses = w.statuses[0], w.statuses[1:]
+		}
+		w.mutex.Unlock()
+
+		response.WriteHeader(status)
+	}))
+	t.Cleanup(w.server.Close)
+	return w
+}
+
+func (w *webhook) calls() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.requests)
+}
+
+func shortenRetryDelay(t *testing.T) {
+	delay := notifyRetryDelay
+	notifyRetryDelay = time.Millisecond
+	t.Cleanup(func() { notifyRetryDelay = delay })
+}
+
+func testReport() Report {
+	started := time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)
+	finished := started.Add(90 * time.Second)
+	return Report{
+		Event:    ReportEventFinished,
+		Context:  "staging",
+		Server:   "https://staging.example.com",
+		User:     "alice",
+		KubeUser: "alice@staging",
+		Selection: ReportSelection{
+			Scope:      ScopeNamespaces,
+			Namespaces: []string{"dev"},
+			Only:       []string{"deploy"},
+			OlderThan:  "24h0m0s",
+			Strategy:   StrategyContentsFirst,
+		},
+		Counts: &ReportCounts{
+			Found:   3,
+			Deleted: 2,
+			Failed:  1,
+			Kinds:   map[string]KindCount{"deployment": {Found: 3, Deleted: 2, Failed: 1}},
+		},
+		Failures:   []string{"failed to delete deployment web: forbidden"},
+		StartedAt:  started,
+		FinishedAt: &finished,
+		Duration:   90,
+	}
+}
+
+func TestNotifySendsSignedReport(t *testing.T) {
+	receiver := newWebhook(t)
+	secret := "s3cret"
+
+	err := Notify(context.Background(), NotifyOptions{URLs: []string{receiver.server.URL}, Secret: secret, Retries: 3, Timeout: time.Second}, testReport())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if receiver.calls() != 1 {
+		t.Fatalf("expected 1 request, got %d", receiver.calls())
+	}
+
+	request, body := receiver.requests[0], receiver.bodies[0]
+	if request.Method != http.MethodPost {
+		t.Errorf("expected POST, got %s", request.Method)
+	}
+	if contentType := request.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected a JSON content type, got %q", contentType)
+	}
+	if event := request.Header.Get(eventHeader); event != ReportEventFinished {
+		t.Errorf("expected event header %q, got %q", ReportEventFinished, event)
+	}
+
+	// verified the way a receiver would, without Signature
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := request.Header.Get(signatureHeader); !hmac.Equal([]byte(signature), []byte(expected)) {
+		t.Errorf("signature %q doesn't verify, expected %q", signature, expected)
+	}
+
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("payload isn't JSON: %v", err)
+	}
+	for field, value := range map[string]interface{}{
+		"event":      ReportEventFinished,
+		"context":    "staging",
+		"server":     "https://staging.example.com",
+		"user":       "alice",
+		"kubeUser":   "alice@staging",
+		"startedAt":  "2026-10-19T03:00:00Z",
+		"finishedAt": "2026-10-19T03:01:30Z",
+		"duration":   float64(90),
+	} {
+		if payload[field] != value {
+			t.Errorf("expected %s to be %v, got %v", field, value, payload[field])
+		}
+	}
+
+	selection := payload["selection"].(map[string]interface{})
+	if selection["scope"] != ScopeNamespaces || selection["olderThan"] != "24h0m0s" || selection["dryRun"] != false {
+		t.Errorf("unexpected selection: %v", selection)
+	}
+	if namespaces := selection["namespaces"].([]interface{}); len(namespaces) != 1 || namespaces[0] != "dev" {
+		t.Errorf("unexpected namespaces: %v", namespaces)
+	}
+
+	counts := payload["counts"].(map[string]interface{})
+	if counts["found"] != float64(3) || counts["deleted"] != float64(2) || counts["failed"] != float64(1) {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+	deployments := counts["kinds"].(map[string]interface{})["deployment"].(map[string]interface{})
+	if deployments["deleted"] != float64(2) {
+		t.Errorf("unexpected deployment counts: %v", deployments)
+	}
+	if failures := payload["failures"].([]interface{}); len(failures) != 1 || !strings.Contains(failures[0].(string), "forbidden") {
+		t.Errorf("unexpected failures: %v", failures)
+	}
+}
+
+func TestNotifyWithoutSecretIsUnsigned(t *testing.T) {
+	receiver := newWebhook(t)
+
+	if err := Notify(context.Background(), NotifyOptions{URLs: []string{receiver.server.URL}, Timeout: time.Second}, testReport()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if signature := receiver.requests[0].Header.Get(signatureHeader); signature != "" {
+		t.Errorf("expected no signature, got %q", signature)
+	}
+}
+
+func TestNotifyRetriesServerErrors(t *testing.T) {
+	shortenRetryDelay(t)
+
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		receiver := newWebhook(t, status, status)
+
+		err := Notify(context.Background(), NotifyOptions{URLs: []string{receiver.server.URL}, Retries: 3, Timeout: time.Second}, testReport())
+		if err != nil {
+			t.Errorf("status %d: expected the third attempt to succeed, got %v", status, err)
+		}
+		if receiver.calls() != 3 {
+			t.Errorf("status %d: expected 3 requests, got %d", status, receiver.calls())
+		}
+
+		// every attempt sends the same payload
+		for _, body := range receiver.bodies[1:] {
+			if string(body) != string(receiver.bodies[0]) {
+				t.Errorf("status %d: retried with a different payload", status)
+			}
+		}
+	}
+}
+
+func TestNotifyGivesUpAfterRetries(t *testing.T) {
+	shortenRetryDelay(t)
+	receiver := newWebhook(t, 500, 500, 500, 500, 500)
+
+	err := Notify(context.Background(), NotifyOptions{URLs: []string{receiver.server.URL}, Retries: 2, Timeout: time.Second}, testReport())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if receiver.calls() != 3 {
+		t.Errorf("expected 1 attempt and 2 retries, got %d requests", receiver.calls())
+	}
+	if !strings.Contains(err.Error(), receiver.server.URL) || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected the URL and status in the error, got %v", err)
+	}
+}
+
+func TestNotifyDoesNotRetryClientErrors(t *testing.T) {
+	shortenRetryDelay(t)
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		receiver := newWebhook(t, status)
+
+		err := Notify(context.Background(), NotifyOptions{URLs: []string{receiver.server.URL}, Retries: 3, Timeout: time.Second}, testReport())
+		if err == nil {
+			t.Errorf("status %d: expected an error", status)
+		}
+		if receiver.calls() != 1 {
+			t.Errorf("status %d: expected no retries, got %d requests", status, receiver.calls())
+		}
+	}
+}
+
+func TestNotifyReportsEachFailedURL(t *testing.T) {
+	shortenRetryDelay(t)
+	ok := newWebhook(t)
+	failing := newWebhook(t, http.StatusBadRequest)
+
+	err := Notify(context.Background(), NotifyOptions{URLs: []string{failing.server.URL, ok.server.URL}, Timeout: time.Second}, testReport())
+	if err == nil || !strings.Contains(err.Error(), "failed to notify 1 of 2 URLs") {
+		t.Errorf("expected one of two URLs to fail, got %v", err)
+	}
+	if ok.calls() != 1 {
+		t.Errorf("expected the other URL to be notified anyway, got %d requests", ok.calls())
+	}
+}
+
+func TestSignature(t *testing.T) {
+	// echo -n payload | openssl dgst -sha256 -hmac key
+	expected := "sha256=5d98b45c90a207fa998ce639fea6f02ecc8cc3f36fef81d694fb856b4d0a28ca"
+
+	if signature := Signature("key", []byte("payload")); signature != expected {
+		t.Errorf("expected %s, got %s", expected, signature)
+	}
+	if Signature("other", []byte("payload")) == expected {
+		t.Error("expected a different key to give a different signature")
+	}
+}
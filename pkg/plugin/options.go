@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// Options controls what RunPlugin is allowed to purge
+type Options struct {
+	Guardrails Guardrails
+
+	// what the run purges, ScopeCluster by default
+	Scope string
+	// the namespaces purged with ScopeNamespaces
+	Namespaces []string
+	// purge the contents of namespaces, but keep the namespaces themselves
+	KeepNamespaces bool
+	// with ScopeCrds, only purge the CRDs of these API groups
+	CrdGroups []string
+	// log what would be deleted, without deleting anything
+	DryRun bool
+
+	// only purge objects at least this old, 0 disables the filter
+	OlderThan time.Duration
+	// only purge objects at most this old, 0 disables the filter
+	NewerThan time.Duration
+	// judge a namespace's age by the newest object inside it, rather than the namespace itself
+	NamespaceActivity bool
+
+	// only purge these Helm releases, given as "name" or "namespace/name"
+	HelmReleases []string
+	// only purge Helm releases, all of them unless HelmReleases is set
+	HelmReleasesOnly bool
+
+	// purge the kinds that are allowed when the access check finds missing permissions, instead of refusing to start
+	AllowPartial bool
+
+	// only purge these kinds, as kubectl resource names or short names
+	Only []string
+	// never purge these kinds, as kubectl resource names or short names
+	Skip []string
+
+	// keep PersistentVolumeClaims and PersistentVolumes
+	KeepStorage bool
+	// with KeepStorage, set the volumes of purged namespaces to Retain, and make them available to be bound again
+	RetainVolumes bool
+
+	// distribution profile whose objects are protected, ProfileAuto detects it from the cluster
+	Profile string
+
+	// delete the objects the control plane owns too, e.g. the kubernetes Service or kube-root-ca.crt ConfigMaps
+	ForceControlPlane bool
+
+	// delete objects that have owners too, instead of leaving them to the garbage collector
+	Exhaustive bool
+	// how long to wait for the garbage collector to delete the dependents of deleted objects
+	DependentsTimeout time.Duration
+
+	// inventory what is left after the run, and fail if anything unexpected remains
+	Verify bool
+	// how long verifying waits for terminating objects to disappear
+	VerifyTimeout time.Duration
+
+	// stop the workloads and wait for their pods to terminate with their own grace period, before deleting anything
+	Gentle bool
+	// with Gentle, evict pods through the Eviction API so PodDisruptionBudgets are honoured
+	Evict bool
+	// with Gentle, how long to wait for the pods to terminate
+	DrainTimeout time.Duration
+
+	// force-delete pods still terminating this long after their deletion, if their node is NotReady or gone
+	StuckPodTimeout time.Duration
+
+	// how long to wait for the cloud to clean up the load balancers of deleted LoadBalancer Services
+	LoadBalancerTimeout time.Duration
+
+	// how namespaces that are deleted are purged, StrategyContentsFirst by default
+	Strategy string
+	// with StrategyNamespaceDelete or StrategyHybrid, how long the namespace controller gets before the contents are purged kind by kind
+	NamespaceTimeout time.Duration
+
+	// policy file declaring the hooks run before and after namespaces are purged
+	PolicyFile string
+
+	// repeat the purge until a pass finds nothing to delete, for controllers that recreate objects
+	Converge bool
+	// with Converge, give up after this many passes
+	MaxPasses int
+	// with Converge, how long to wait between passes for controllers to recreate objects
+	SettleInterval time.Duration
+
+	// snapshot file to purge back to, every object that isn't in it is deleted
+	Baseline string
+
+	// receives the progress of the run, if set
+	Progress chan<- ProgressEvent
+
+	// set by RunPlugin after the access check
+	access *accessMatrix
+	// set by RunPlugin from Only and Skip
+	kindFilter *kindFilter
+	// set by RunPlugin from Profile
+	profile *profile
+	// set by RunPlugin, collects the dependents left to the garbage collector
+	dependents *dependentTracker
+	// set by RunPlugin, records the deleted objects for verifying
+	deleted *deletedTracker
+	// set by RunPlugin per pass, counts the objects found to delete
+	pass *passTracker
+	// set by RunPlugin, collects the deleted LoadBalancer Services
+	loadBalancers *loadBalancerTracker
+	// set by RunPlugin from PolicyFile, runs the hooks of the policy file and namespace annotations
+	hooks *hookRunner
+	// set by RunPlugin from Baseline
+	baseline baseline
+}
+
+// selectsAge reports whether an object created at timestamp passes the OlderThan and NewerThan filters
+func (o Options) selectsAge(timestamp metav1.Time) bool {
+	age := time.Since(timestamp.Time)
+	if o.OlderThan > 0 && age < o.OlderThan {
+		return false
+	}
+	if o.NewerThan > 0 && age > o.NewerThan {
+		return false
+	}
+	return true
+}
+
+func (o Options) filtersAge() bool {
+	return o.OlderThan > 0 || o.NewerThan > 0
+}
@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+)
+
+const (
+	// detect the profile from the cluster
+	ProfileAuto = "auto"
+	// don't protect anything beyond the built-in defaults
+	ProfileNone = "none"
+)
+
+var (
+	storageClassesResource      = schema.GroupResource{Group: "storage.k8s.io", Resource: "storageclasses"}
+	ingressClassesResource      = ingressClassesKind.resource.GroupResource()
+	clusterRolesResource        = clusterRolesKind.resource.GroupResource()
+	clusterRoleBindingsResource = clusterRoleBindingsKind.resource.GroupResource()
+	crdsResource                = crdsKind.resource.GroupResource()
+)
+
+// profile is what a distribution installs and needs to keep working, as glob patterns of names
+type profile struct {
+	name       string
+	namespaces []string
+	// names of cluster-scoped objects, by resource
+	objects map[schema.GroupResource][]string
+}
+
+// protectsNamespace reports whether the namespace belongs to the distribution
+func (p *profile) protectsNamespace(name string) bool {
+	return p != nil && util.MatchesAnyGlob(p.namespaces, name)
+}
+
+// protects reports whether the cluster-scoped object of this kind belongs to the distribution
+func (p *profile) protects(kind purgeKind, name string) bool {
+	return p != nil && !kind.namespaced && util.MatchesAnyGlob(p.objects[kind.resource.GroupResource()], name)
+}
+
+var profiles = []profile{
+	{
+		name: "docker-desktop",
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"vpnkit-controller"},
+			clusterRoleBindingsResource: {"docker-for-desktop-binding", "vpnkit-controller"},
+			storageClassesResource:      {"hostpath"},
+		},
+	},
+	{
+		name:       "microk8s",
+		namespaces: []string{"container-registry", "ingress"},
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"microk8s*"},
+			clusterRoleBindingsResource: {"microk8s*"},
+			storageClassesResource:      {"microk8s-hostpath"},
+			ingressClassesResource:      {"public", "nginx"},
+		},
+	},
+	{
+		name:       "kind",
+		namespaces: []string{"local-path-storage"},
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"kindnet", "local-path-provisioner-role"},
+			clusterRoleBindingsResource: {"kindnet", "local-path-provisioner-bind"},
+			storageClassesResource:      {"standard"},
+		},
+	},
+	{
+		name: "k3s",
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"k3s*", "local-path-provisioner-role", "clustercidrs-node"},
+			clusterRoleBindingsResource: {"k3s*", "local-path-provisioner-bind", "kube-apiserver-kubelet-admin", "helm-kube-system-*", "clustercidrs-node"},
+			crdsResource:                {"*.k3s.cattle.io", "*.helm.cattle.io", "*.traefik.containo.us", "*.traefik.io"},
+			storageClassesResource:      {"local-path"},
+			ingressClassesResource:      {"traefik"},
+		},
+	},
+	{
+		name: "minikube",
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"minikube*"},
+			clusterRoleBindingsResource: {"minikube*", "storage-provisioner"},
+			storageClassesResource:      {"standard"},
+		},
+	},
+	{
+		name: "eks",
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"eks:*", "aws-node"},
+			clusterRoleBindingsResource: {"eks:*", "aws-node"},
+			crdsResource:                {"*.k8s.aws", "*.crd.k8s.amazonaws.com"},
+			storageClassesResource:      {"gp2", "gp3"},
+		},
+	},
+	{
+		name:       "gke",
+		namespaces: []string{"gke-*", "gmp-*", "config-management-*"},
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"gke-*", "gce:*", "cloud-provider", "ca-cr-actor"},
+			clusterRoleBindingsResource: {"gke-*", "gce:*", "cloud-provider", "ca-cr"},
+			crdsResource:                {"*.gke.io", "*.cloud.google.com", "*.googleapis.com"},
+			storageClassesResource:      {"standard", "standard-rwo", "premium-rwo"},
+		},
+	},
+	{
+		name:       "aks",
+		namespaces: []string{"gatekeeper-system", "calico-system", "tigera-operator"},
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"aks-*", "azure-*"},
+			clusterRoleBindingsResource: {"aks-*", "azure-*"},
+			crdsResource:                {"*.azure.com", "*.projectcalico.org", "*.tigera.io"},
+			storageClassesResource:      {"default", "managed*", "azurefile*"},
+		},
+	},
+	{
+		name:       "openshift",
+		namespaces: []string{"openshift", "openshift-*"},
+		objects: map[schema.GroupResource][]string{
+			clusterRolesResource:        {"openshift*", "cluster-*", "basic-user", "self-*", "registry-*", "storage-admin", "sudoer"},
+			clusterRoleBindingsResource: {"openshift*", "cluster-*", "basic-users", "self-*", "registry-*"},
+			crdsResource:                {"*.openshift.io", "*.coreos.com", "*.metal3.io", "*.k8s.ovn.org", "*.cni.cncf.io"},
+			ingressClassesResource:      {"openshift-default"},
+		},
+	},
+}
+
+// profileDetectors recognize a distribution, tried in order
+var profileDetectors = []struct {
+	profile string
+	// node label keys that only this distribution sets
+	nodeLabels []string
+	// substrings of the server's git version
+	versions []string
+	// namespaces that only this distribution creates
+	namespaces []string
+	// node names that only this distribution uses
+	nodeNames []string
+	// prefixes of the providerID of nodes, which only this distribution uses
+	providerIDs []string
+	// glob patterns of ClusterRoles and ClusterRoleBindings that only this distribution creates
+	clusterRoles        []string
+	clusterRoleBindings []string
+}{
+	{profile: "openshift", nodeLabels: []string{"node.openshift.io/os_id"}, namespaces: []string{"openshift-apiserver"}},
+	{profile: "eks", nodeLabels: []string{"eks.amazonaws.com/nodegroup", "eks.amazonaws.com/compute-type"}, versions: []string{"-eks-"}},
+	{profile: "gke", nodeLabels: []string{"cloud.google.com/gke-nodepool"}, versions: []string{"-gke."}},
+	{profile: "aks", nodeLabels: []string{"kubernetes.azure.com/cluster"}},
+	{profile: "k3s", nodeLabels: []string{"node.k3s.io/hostname"}, versions: []string{"+k3s"}},
+	{profile: "microk8s", nodeLabels: []string{"microk8s.io/cluster"}, clusterRoles: []string{"microk8s*"}, clusterRoleBindings: []string{"microk8s*"}},
+	{profile: "minikube", nodeLabels: []string{"minikube.k8s.io/name"}},
+	{profile: "kind", providerIDs: []string{"kind://"}},
+	{profile: "docker-desktop", nodeNames: []string{"docker-desktop"}, clusterRoles: []string{"vpnkit-controller"}, clusterRoleBindings: []string{"docker-for-desktop-binding", "vpnkit-controller"}},
+}
+
+// ProfileNames are the names accepted by --profile
+func ProfileNames() []string {
+	names := []string{ProfileAuto, ProfileNone}
+	for _, p := range profiles {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+func findProfile(name string) (*profile, error) {
+	if name == ProfileNone {
+		return nil, nil
+	}
+	for i := range profiles {
+		if profiles[i].name == name {
+			return &profiles[i], nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("unknown profile %s, must be one of: %s", name, strings.Join(ProfileNames(), ", ")))
+}
+
+// resolveProfile returns the profile called name, or the one detected from the cluster for ProfileAuto
+func resolveProfile(ctx context.Context, clientset *kubernetes.Clientset, name string, logCh chan<- string) (*profile, error) {
+	if name == "" || name == ProfileAuto {
+		detected, err := detectProfile(ctx, clientset, logCh)
+		if err != nil {
+			return nil, err
+		}
+		if detected == "" {
+			logCh <- "No distribution detected, using no profile"
+			return nil, nil
+		}
+		logCh <- fmt.Sprintf("Detected distribution, using profile: %s", detected)
+		name = detected
+	}
+	return findProfile(name)
+}
+
+// detectProfile guesses the distribution from the server version, nodes, namespaces and RBAC objects, "" if none matches.
+// Whatever may not be listed, e.g. by users that can only access their own namespaces, is left out.
+func detectProfile(ctx context.Context, clientset *kubernetes.Clientset, logCh chan<- string) (string, error) {
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read server version")
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		logCh <- "Not allowed to list nodes, detecting the distribution without them"
+		nodes = &corev1.NodeList{}
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to list nodes")
+	}
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		logCh <- "Not allowed to list namespaces, detecting the distribution without them"
+		namespaces = &corev1.NamespaceList{}
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to list namespaces")
+	}
+
+	// ClusterRoles and ClusterRoleBindings that may not be listed can't be purged either
+	clusterRoles, err := clientset.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		clusterRoles = &rbacv1.ClusterRoleList{}
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to list clusterRoles")
+	}
+
+	clusterRoleBindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		clusterRoleBindings = &rbacv1.ClusterRoleBindingList{}
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to list clusterRoleBindings")
+	}
+
+	for _, detector := range profileDetectors {
+		for _, versionPart := range detector.versions {
+			if strings.Contains(version.GitVersion, versionPart) {
+				return detector.profile, nil
+			}
+		}
+		for _, node := range nodes.Items {
+			for _, label := range detector.nodeLabels {
+				if _, ok := node.Labels[label]; ok {
+					return detector.profile, nil
+				}
+			}
+			if util.Contains(detector.nodeNames, node.Name) || util.StartsWithAny(detector.providerIDs, node.Spec.ProviderID) {
+				return detector.profile, nil
+			}
+		}
+		for _, namespace := range namespaces.Items {
+			if util.Contains(detector.namespaces, namespace.Name) {
+				return detector.profile, nil
+			}
+		}
+		for _, clusterRole := range clusterRoles.Items {
+			if util.MatchesAnyGlob(detector.clusterRoles, clusterRole.Name) {
+				return detector.profile, nil
+			}
+		}
+		for _, clusterRoleBinding := range clusterRoleBindings.Items {
+			if util.MatchesAnyGlob(detector.clusterRoleBindings, clusterRoleBinding.Name) {
+				return detector.profile, nil
+			}
+		}
+	}
+	return "", nil
+}
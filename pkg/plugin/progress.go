@@ -0,0 +1,20 @@
+package plugin
+
+// the kind reported for the deletion of a namespace itself
+const NamespaceProgressKind = "namespace"
+
+// ProgressEvent reports the progress of deleting one kind in one namespace, "" for cluster-scoped kinds.
+// Found is sent once after listing, Deleted and Failed once per object.
+type ProgressEvent struct {
+	Namespace string
+	Kind      string
+	Found     int
+	Deleted   int
+	Failed    int
+}
+
+func (o Options) reportProgress(event ProgressEvent) {
+	if o.Progress != nil {
+		o.Progress <- event
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/robertsmieja/kubectl-purge/pkg/util"
 	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
 	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"sync"
+	"time"
 )
 
 var gracePeriodSeconds = int64(0)
@@ -26,7 +29,40 @@ func createCtx() (context.Context, context.CancelFunc) {
 	return context.WithCancel(context.Background())
 }
 
-func RunPlugin(configFlags *genericclioptions.ConfigFlags, logCh chan<- string, errorCh chan<- error) error {
+// clients are the API clients shared by the delete helpers
+type clients struct {
+	clientset     *kubernetes.Clientset
+	apixClient    *apixv1client.ApiextensionsV1Client
+	dynamicClient dynamic.Interface
+}
+
+func newClients(config *rest.Config) (*clients, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create clientset")
+	}
+
+	apixClient, err := apixv1client.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create apiextensions client")
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dynamic client")
+	}
+
+	return &clients{
+		clientset:     clientset,
+		apixClient:    apixClient,
+		dynamicClient: dynamicClient,
+	}, nil
+}
+
+func RunPlugin(configFlags *genericclioptions.ConfigFlags, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	defer close(logCh)
+	defer close(errorCh)
+
 	ctx, cancel := createCtx()
 	defer cancel()
 
@@ -35,190 +71,488 @@ func RunPlugin(configFlags *genericclioptions.ConfigFlags, logCh chan<- string,
 		return errors.Wrap(err, "failed to read kubeconfig")
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	c, err := newClients(config)
 	if err != nil {
-		return errors.Wrap(err, "failed to create clientset")
+		return err
 	}
+	clientset := c.clientset
 
-	apixClient, err := apixv1client.NewForConfig(config)
+	if err := checkStrategy(opts.Strategy); err != nil {
+		return err
+	}
+	if err := checkBaseline(opts); err != nil {
+		return err
+	}
+	if err := checkHelmReleases(opts); err != nil {
+		return err
+	}
+
+	if err := checkGuardrails(ctx, opts.Guardrails, configFlags, config, clientset); err != nil {
+		return errors.Wrap(err, "refusing to purge, pass --i-know-what-im-doing to override")
+	}
+
+	opts, err = prepareRun(ctx, clientset, configFlags, opts, logCh)
 	if err != nil {
-		return errors.Wrap(err, "failed to create apiextensions client")
+		return err
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(config)
+	mapper, err := configFlags.ToRESTMapper()
 	if err != nil {
-		return errors.Wrap(err, "failed to create dynamic client")
+		return errors.Wrap(err, "failed to create REST mapper")
+	}
+	opts.dependents = newDependentTracker()
+	opts.deleted = newDeletedTracker()
+
+	if opts.purgesHelmReleases() {
+		opts.loadBalancers = newLoadBalancerTracker()
+		namespaces, err := purgeHelmReleases(ctx, c, mapper, opts, logCh, errorCh)
+		if err != nil {
+			return err
+		}
+		verifyDependents(ctx, c, mapper, opts, logCh, errorCh)
+		// leaked load balancers fail the run
+		leakErr := waitForLoadBalancers(ctx, clientset, opts.loadBalancers.list(), opts, logCh, errorCh)
+		reportRetainedVolumes(ctx, c, namespaces, opts, logCh, errorCh)
+		return leakErr
+	}
+
+	if opts.Baseline != "" {
+		opts.baseline, err = loadBaseline(ctx, c, opts.Baseline, opts.Guardrails)
+		if err != nil {
+			return err
+		}
+		if err := purgeToBaseline(ctx, c, mapper, opts, logCh, errorCh); err != nil {
+			return err
+		}
+		verifyDependents(ctx, c, mapper, opts, logCh, errorCh)
+		return nil
 	}
 
 	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return errors.Wrap(err, "failed to list namespaces")
 	}
+	selectedNamespaces := selectNamespaces(ctx, c, opts.scopedNamespaces(namespaces.Items, errorCh), opts, logCh, errorCh)
 
-	// wait for all the goroutines per cluster
-	clusterWaitGroup := sync.WaitGroup{}
+	if err := preflightAccess(ctx, clientset, planKinds(ctx, c, opts), selectedNamespaces, &opts, logCh); err != nil {
+		return err
+	}
 
-	// wait for all the goroutines per namespace
-	namespaceWaitGroup := sync.WaitGroup{}
+	opts.loadBalancers = newLoadBalancerTracker()
+
+	purgedNamespaces := selectedNamespaces
+	recurring := recurringKinds{}
+	for pass := 1; ; pass++ {
+		opts.pass = newPassTracker()
+		opts.dependents = newDependentTracker()
+		purgePass(c, selectedNamespaces, opts, logCh, errorCh)
+		verifyDependents(ctx, c, mapper, opts, logCh, errorCh)
+		if !opts.Converge || opts.DryRun {
+			break
+		}
 
-	clusterWaitGroup.Add(1)
-	go func() {
-		deleteClusterRoleBindings(clientset, logCh, errorCh)
-		clusterWaitGroup.Done()
-	}()
+		found := opts.pass.total()
+		if found == 0 {
+			logCh <- fmt.Sprintf("Converged after %d passes", pass)
+			if len(recurring) > 0 {
+				logCh <- fmt.Sprintf("Kinds that kept coming back: %s", recurring)
+			}
+			break
+		}
+		// everything found after the first pass was recreated, or failed to be deleted before
+		if pass > 1 {
+			recurring.add(opts.pass)
+		}
+		if pass >= opts.MaxPasses {
+			errorCh <- errors.New(fmt.Sprintf("did not converge after %d passes, kinds that kept coming back: %s", pass, recurring))
+			break
+		}
+		logCh <- fmt.Sprintf("Pass %d found %d objects, purging again in %s", pass, found, opts.SettleInterval)
+		time.Sleep(opts.SettleInterval)
 
-	clusterWaitGroup.Add(1)
-	go func() {
-		deleteClusterRoles(clientset, logCh, errorCh)
-		clusterWaitGroup.Done()
-	}()
+		// controllers may have created new namespaces too
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to list namespaces")
+		}
+		selectedNamespaces = selectNamespaces(ctx, c, opts.scopedNamespaces(namespaces.Items, errorCh), opts, logCh, errorCh)
+		purgedNamespaces = appendMissing(purgedNamespaces, selectedNamespaces)
+		rows, err := opts.access.reviewNamespaces(ctx, clientset, selectedNamespaces)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if !row.canPurge() {
+				errorCh <- errors.New(fmt.Sprintf("missing permissions for %s in new namespace %s, skipping them", row.kind.resource.GroupResource(), row.namespace))
+			}
+		}
+	}
+	leakErr := waitForLoadBalancers(ctx, clientset, opts.loadBalancers.list(), opts, logCh, errorCh)
+	reportRetainedVolumes(ctx, c, purgedNamespaces, opts, logCh, errorCh)
 
-	clusterWaitGroup.Add(1)
-	go func() {
-		deletePodSecurityPolicies(clientset, logCh, errorCh)
-		clusterWaitGroup.Done()
-	}()
+	var verifyErr error
+	if opts.Verify && !opts.DryRun {
+		verifyErr = verifyPurge(ctx, c, mapper, purgedNamespaces, opts, logCh)
+	}
+	// leaked load balancers fail the run, apart from the objects that failed to be deleted
+	if leakErr != nil {
+		if verifyErr == nil {
+			verifyErr = leakErr
+		} else {
+			errorCh <- leakErr
+		}
+	}
+	return verifyErr
+}
 
-	clusterWaitGroup.Add(1)
-	go func() {
-		deleteIngressClasses(clientset, logCh, errorCh)
-		clusterWaitGroup.Done()
-	}()
+// prepareRun resolves the profile, the kind filter and the hooks shared by every purge of a run
+func prepareRun(ctx context.Context, clientset *kubernetes.Clientset, configFlags *genericclioptions.ConfigFlags, opts Options, logCh chan<- string) (Options, error) {
+	profile, err := resolveProfile(ctx, clientset, opts.Profile, logCh)
+	if err != nil {
+		return opts, err
+	}
+	opts.profile = profile
 
-	for _, namespace := range namespaces.Items {
-		namespaceName := namespace.Name
+	opts.kindFilter, err = resolveKindFilter(configFlags, opts)
+	if err != nil {
+		return opts, err
+	}
 
-		if util.Contains(systemNamespaces, namespaceName) {
-			logCh <- fmt.Sprintf("Skipping system namespace: %s", namespaceName)
-			continue
+	var policy *Policy
+	if opts.PolicyFile != "" {
+		policy, err = LoadPolicy(opts.PolicyFile)
+		if err != nil {
+			return opts, err
 		}
+	}
+	contextName, err := currentContextName(configFlags)
+	if err != nil {
+		return opts, err
+	}
+	opts.hooks = newHookRunner(policy, contextName)
+	return opts, nil
+}
 
-		logCh <- fmt.Sprintf("Deleting namespace: %s", namespaceName)
+// purgePass purges namespaces and the cluster-scoped objects once
+func purgePass(c *clients, namespaces []string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	clientset := c.clientset
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteNamespacedCrds(apixClient, dynamicClient, namespaceName, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+	// wait for all the goroutines per cluster
+	clusterWaitGroup := sync.WaitGroup{}
 
-		namespaceWaitGroup.Add(1)
+	if opts.purgesCluster() {
+		clusterWaitGroup.Add(1)
 		go func() {
-			deletePersistentVolumeClaims(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deleteClusterRoleBindings(clientset, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
 
-		namespaceWaitGroup.Add(1)
+		clusterWaitGroup.Add(1)
 		go func() {
-			deleteConfigMaps(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deleteClusterRoles(clientset, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
 
-		namespaceWaitGroup.Add(1)
+		clusterWaitGroup.Add(1)
 		go func() {
-			deleteEndpoints(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deletePodSecurityPolicies(clientset, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
 
-		namespaceWaitGroup.Add(1)
+		clusterWaitGroup.Add(1)
 		go func() {
-			// RoleBindings should be deleted BEFORE Roles
-			deleteRoleBindings(clientset, namespaceName, logCh, errorCh)
-			deleteRoles(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deleteIngressClasses(clientset, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
+	}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteIngresses(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+	for _, namespace := range namespaces {
+		namespace := namespace
+		logCh <- fmt.Sprintf("Purging namespace: %s", namespace)
 
-		namespaceWaitGroup.Add(1)
+		clusterWaitGroup.Add(1)
 		go func() {
-			deleteNetworkPolicies(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			defer clusterWaitGroup.Done()
+			purgeNamespaceWithStrategy(c, namespace, opts, logCh, errorCh)
 		}()
+	}
 
-		namespaceWaitGroup.Add(1)
+	// Delete cluster CRDs after namespaces are cleaned up
+	if opts.purgesCluster() || opts.Scope == ScopeCrds {
+		logCh <- "Deleting cluster CRDs"
+		clusterWaitGroup.Add(1)
 		go func() {
-			// CronJobs may kick off Jobs, they should go 1st
-			deleteCronJobs(clientset, namespaceName, logCh, errorCh)
-			deleteJobs(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deleteClusterCrds(c.apixClient, c.dynamicClient, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
+	}
 
-		namespaceWaitGroup.Add(1)
+	// delete PersistentVolumes after the namespaced PersistentVolumeClaims are deleted
+	if opts.purgesCluster() {
+		clusterWaitGroup.Add(1)
 		go func() {
-			deleteDeployments(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
+			deletePersistentVolumes(clientset, opts, logCh, errorCh)
+			clusterWaitGroup.Done()
 		}()
+	}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteDaemonSets(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+	clusterWaitGroup.Wait()
+}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteStatefulSets(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+// appendMissing appends the values of added that aren't in values yet
+func appendMissing(values []string, added []string) []string {
+	for _, value := range added {
+		if !util.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteReplicaSets(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+// preflightAccess checks the permissions for kinds in namespaces, and records them in opts so that kinds which can't be purged are skipped
+func preflightAccess(ctx context.Context, clientset *kubernetes.Clientset, kinds []purgeKind, namespaces []string, opts *Options, logCh chan<- string) error {
+	// with ScopeCrds, custom resources are purged across all namespaces at once
+	access, err := checkAccess(ctx, clientset, kinds, namespaces, opts.Scope != ScopeCrds)
+	if err != nil {
+		return err
+	}
+	if !access.complete() && !opts.AllowPartial {
+		return errors.New(fmt.Sprintf("missing permissions, pass --allow-partial to purge the kinds that are allowed\n%s", access))
+	}
+	logCh <- fmt.Sprintf("Permissions:\n%s", access)
+	opts.access = access
+	return nil
+}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteSecrets(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+// selectNamespaces returns the names of the namespaces that should be purged
+func selectNamespaces(ctx context.Context, c *clients, namespaces []corev1.Namespace, opts Options, logCh chan<- string, errorCh chan<- error) []string {
+	var selected []string
+	for _, namespace := range namespaces {
+		namespaceName := namespace.Name
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deletePodDisruptionBudgets(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+		if util.Contains(systemNamespaces, namespaceName) {
+			logCh <- verbose(fmt.Sprintf("Skipping system namespace: %s", namespaceName))
+			continue
+		}
 
-		namespaceWaitGroup.Add(1)
-		go func() {
-			deleteEvents(clientset, namespaceName, logCh, errorCh)
-			namespaceWaitGroup.Done()
-		}()
+		if namespace.DeletionTimestamp != nil {
+			logCh <- verbose(fmt.Sprintf("Skipping terminating namespace: %s", namespaceName))
+			continue
+		}
+
+		if opts.profile.protectsNamespace(namespaceName) {
+			logCh <- verbose(fmt.Sprintf("Skipping namespace of the %s profile: %s", opts.profile.name, namespaceName))
+			continue
+		}
 
-		// cleanup the namespace after everything is done
-		if namespaceName != "default" {
-			clusterWaitGroup.Add(1)
-			go func() {
-				defer clusterWaitGroup.Done()
-				namespaceWaitGroup.Wait()
-				if err := clientset.CoreV1().Namespaces().Delete(ctx, namespaceName, deletePolicy); err != nil {
-					errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete namespace: %s", namespaceName))
-				}
-			}()
+		if namespace.Labels[protectedLabel] == "true" {
+			logCh <- verbose(fmt.Sprintf("Skipping protected namespace: %s", namespaceName))
+			continue
 		}
+
+		selectsAge, err := selectsNamespace(ctx, c.clientset, c.dynamicClient, namespace, opts)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to check age of namespace: %s", namespaceName))
+			continue
+		}
+		if !selectsAge {
+			logCh <- verbose(fmt.Sprintf("Skipping namespace outside of the age filter: %s", namespaceName))
+			continue
+		}
+
+		selected = append(selected, namespaceName)
 	}
+	return selected
+}
 
-	// Delete cluster CRDs after namespaces are cleaned up
-	logCh <- "Deleting cluster CRDs"
-	clusterWaitGroup.Add(1)
+// planKinds returns every kind a run may delete, including namespaces and custom resources
+func planKinds(ctx context.Context, c *clients, opts Options) []purgeKind {
+	kinds := opts.scopedKinds(append([]purgeKind{namespacesKind}, opts.enabledKinds()...))
+
+	// if CRDs can't be listed, the access check reports it for the crds kind
+	crds, err := c.apixClient.CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return kinds
+	}
+	for _, crd := range crds.Items {
+		kind := customResourceKind(crd)
+		if !opts.selectsCrd(crd) || (opts.Scope == ScopeNamespaces && !kind.namespaced) {
+			continue
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// purgeNamespace deletes the contents of a namespace, and then the namespace itself unless it is "default"
+func purgeNamespace(c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	// wait for all the goroutines per namespace
+	namespaceWaitGroup := sync.WaitGroup{}
+
+	// before the claims are deleted along with the namespace
+	retainVolumes(ctx, c, namespace, opts, logCh, errorCh)
+
+	// stop the workloads, so their pods shut down before they are deleted
+	drainNamespace(ctx, c.clientset, namespace, opts, logCh, errorCh)
+
+	namespaceWaitGroup.Add(1)
 	go func() {
-		deleteClusterCrds(apixClient, dynamicClient, errorCh)
-		clusterWaitGroup.Done()
+		deleteNamespacedCustomResources(c.apixClient, c.dynamicClient, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
 	}()
 
-	// delete PersistentVolumes after the namespaced PersistentVolumeClaims are deleted
-	clusterWaitGroup.Add(1)
+	namespaceWaitGroup.Add(1)
 	go func() {
-		deletePersistentVolumes(clientset, logCh, errorCh)
-		clusterWaitGroup.Done()
+		deletePersistentVolumeClaims(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
 	}()
 
-	clusterWaitGroup.Wait()
-	close(logCh)
-	close(errorCh)
-	return nil
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteConfigMaps(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		// Services should be deleted BEFORE their EndpointSlices and Endpoints, the controllers recreate them otherwise
+		deleteServices(c.clientset, namespace, opts, logCh, errorCh)
+		deleteEndpointSlices(c.clientset, namespace, opts, logCh, errorCh)
+		deleteEndpoints(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteServiceAccounts(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteResourceQuotas(c.clientset, namespace, opts, logCh, errorCh)
+		deleteLimitRanges(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		// RoleBindings should be deleted BEFORE Roles
+		deleteRoleBindings(c.clientset, namespace, opts, logCh, errorCh)
+		deleteRoles(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteIngresses(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteNetworkPolicies(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		// CronJobs may kick off Jobs, they should go 1st
+		deleteCronJobs(c.clientset, namespace, opts, logCh, errorCh)
+		deleteJobs(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteDeployments(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteDaemonSets(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteStatefulSets(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteReplicaSets(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deletePods(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteSecrets(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deletePodDisruptionBudgets(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	namespaceWaitGroup.Add(1)
+	go func() {
+		deleteEvents(c.clientset, namespace, opts, logCh, errorCh)
+		namespaceWaitGroup.Done()
+	}()
+
+	// cleanup the namespace after everything is done
+	namespaceWaitGroup.Wait()
+	forceDeleteStuckPods(ctx, c.clientset, namespace, opts, logCh, errorCh)
+	if deletesNamespace(ctx, c, namespace, opts, logCh, errorCh) {
+		deleteNamespace(ctx, c, namespace, opts, logCh, errorCh)
+	}
+}
+
+// deletesNamespace reports whether namespace itself is deleted by the run, "default" never is
+func deletesNamespace(ctx context.Context, c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) bool {
+	if namespace == metav1.NamespaceDefault || opts.KeepNamespaces || !opts.runsKind(namespacesKind, "") || holdsStorage(ctx, c.clientset, namespace, opts, logCh, errorCh) {
+		return false
+	}
+
+	holdsKept, err := holdsObjectsOutsideAgeFilter(ctx, c, namespace, opts)
+	if err != nil {
+		errorCh <- err
+		return false
+	}
+	if holdsKept {
+		logCh <- fmt.Sprintf("Keeping namespace %s, it holds objects %s", namespace, outsideAgeFilter)
+		return false
+	}
+	return true
+}
+
+// deleteNamespace deletes namespace, and reports whether it did
+func deleteNamespace(ctx context.Context, c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) bool {
+	if opts.DryRun {
+		logCh <- fmt.Sprintf("Would delete namespace: %s", namespace)
+		return false
+	}
+	opts.pass.add(namespacesKind, 1)
+	opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: NamespaceProgressKind, Found: 1})
+	if err := c.clientset.CoreV1().Namespaces().Delete(ctx, namespace, deletePolicy); err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete namespace: %s", namespace))
+		opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: NamespaceProgressKind, Failed: 1})
+		return false
+	}
+	opts.reportProgress(ProgressEvent{Namespace: namespace, Kind: NamespaceProgressKind, Deleted: 1})
+	return true
 }
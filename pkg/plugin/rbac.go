@@ -1,18 +1,19 @@
 package plugin
 
 import (
-	"fmt"
 	"github.com/pkg/errors"
 	"github.com/robertsmieja/kubectl-purge/pkg/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"sync"
 )
 
-func deleteRoles(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteRoles(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(rolesKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.RbacV1().Roles(namespace)
 
@@ -21,25 +22,16 @@ func deleteRoles(clientset *kubernetes.Clientset, namespace string, logCh chan<-
 		errorCh <- errors.Wrap(err, "failed to list roles")
 		return
 	}
-	for _, role := range roles.Items {
-		waitGroup.Add(1)
-
-		name := role.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete role %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, rolesKind, namespace, roles, api.Delete, opts, logCh, errorCh)
 }
 
-func deleteRoleBindings(clientset *kubernetes.Clientset, namespace string, logCh chan<- string, errorCh chan<- error) {
+func deleteRoleBindings(clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(roleBindingsKind, namespace) {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.RbacV1().RoleBindings(namespace)
 
@@ -48,19 +40,7 @@ func deleteRoleBindings(clientset *kubernetes.Clientset, namespace string, logCh
 		errorCh <- errors.Wrap(err, "failed to list roleBindings")
 		return
 	}
-	for _, roleBinding := range roleBindings.Items {
-		waitGroup.Add(1)
-
-		name := roleBinding.Name
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete roleBinding %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, roleBindingsKind, namespace, roleBindings, api.Delete, opts, logCh, errorCh)
 }
 
 var defaultClusterRoles = []string{
@@ -68,19 +48,20 @@ var defaultClusterRoles = []string{
 	"cluster-admin",
 	"edit",
 	"view",
-	"vpnkit-controller", // docker desktop
 }
 
 var defaultClusterRolePrefixes = []string{
 	"kubeadm:",
-	"microk8s", // microk8s
 	"system:",
 }
 
-func deleteClusterRoles(clientset *kubernetes.Clientset, logCh chan<- string, errorCh chan<- error) {
+func deleteClusterRoles(clientset *kubernetes.Clientset, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(clusterRolesKind, "") {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.RbacV1().ClusterRoles()
 
@@ -89,41 +70,25 @@ func deleteClusterRoles(clientset *kubernetes.Clientset, logCh chan<- string, er
 		errorCh <- errors.Wrap(err, "failed to list clusterRoles")
 		return
 	}
-	for _, clusterRole := range clusterRoles.Items {
-		name := clusterRole.Name
-
-		if util.Contains(defaultClusterRoles, name) || util.StartsWithAny(defaultClusterRolePrefixes, name) {
-			logCh <- fmt.Sprintf("Skipping ClusterRole: %s", name)
-			continue
-		}
-
-		waitGroup.Add(1)
-		go func() {
-			defer waitGroup.Done()
-			if err := api.Delete(ctx, name, deletePolicy); err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete clusterRole %s", name))
-			}
-		}()
-	}
-	waitGroup.Wait()
+	deleteObjects(ctx, clusterRolesKind, "", clusterRoles, api.Delete, opts, logCh, errorCh)
 }
 
 var defaultClusterRoleBindings = []string{
 	"cluster-admin",
-	"docker-for-desktop-binding", // docker desktop
-	"vpnkit-controller",          // docker desktop
 }
 
 var defaultClusterRoleBindingPrefixes = []string{
 	"kubeadm:",
-	"microk8s", // microk8s
 	"system:",
 }
 
-func deleteClusterRoleBindings(clientset *kubernetes.Clientset, logCh chan<- string, errorCh chan<- error) {
+func deleteClusterRoleBindings(clientset *kubernetes.Clientset, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(clusterRoleBindingsKind, "") {
+		return
+	}
+
 	ctx, cancel := createCtx()
 	defer cancel()
-	waitGroup := sync.WaitGroup{}
 
 	api := clientset.RbacV1().ClusterRoleBindings()
 
@@ -132,22 +97,16 @@ func deleteClusterRoleBindings(clientset *kubernetes.Clientset, logCh chan<- str
 		errorCh <- errors.Wrap(err, "failed to list clusterRoleBindings")
 		return
 	}
-	for _, clusterRoleBinding := range clusterRoleBindings.Items {
-		name := clusterRoleBinding.Name
-
-		if util.Contains(defaultClusterRoleBindings, name) || util.StartsWithAny(defaultClusterRoleBindingPrefixes, name) {
-			logCh <- fmt.Sprintf("Skipping ClusterRole: %s", name)
-			continue
-		}
-
-		waitGroup.Add(1)
-		go func() {
-			defer waitGroup.Done()
-			err := api.Delete(ctx, name, deletePolicy)
-			if err != nil {
-				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to delete clusterRoleBinding %s", name))
-			}
-		}()
+	deleteObjects(ctx, clusterRoleBindingsKind, "", clusterRoleBindings, api.Delete, opts, logCh, errorCh)
+}
+
+// isDefaultRbacObject reports whether the object is one of the ClusterRoles or ClusterRoleBindings every cluster has
+func isDefaultRbacObject(kind purgeKind, name string) bool {
+	switch kind.resource.GroupResource() {
+	case clusterRolesKind.resource.GroupResource():
+		return util.Contains(defaultClusterRoles, name) || util.StartsWithAny(defaultClusterRolePrefixes, name)
+	case clusterRoleBindingsKind.resource.GroupResource():
+		return util.Contains(defaultClusterRoleBindings, name) || util.StartsWithAny(defaultClusterRoleBindingPrefixes, name)
 	}
-	waitGroup.Wait()
+	return false
 }
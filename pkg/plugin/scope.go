@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+const (
+	// purge every namespace and the cluster-scoped kinds
+	ScopeCluster = "cluster"
+	// only purge the contents of Namespaces
+	ScopeNamespaces = "namespaces"
+	// only purge CRDs and their custom resources
+	ScopeCrds = "crds"
+)
+
+// purgesCluster reports whether the cluster-scoped kinds take part in the run
+func (o Options) purgesCluster() bool {
+	return o.Scope == "" || o.Scope == ScopeCluster
+}
+
+// selectsCrd reports whether crd is in one of CrdGroups, when only CRDs are purged
+func (o Options) selectsCrd(crd apixv1.CustomResourceDefinition) bool {
+	return o.Scope != ScopeCrds || len(o.CrdGroups) == 0 || util.Contains(o.CrdGroups, crd.Spec.Group)
+}
+
+// scopedKinds narrows kinds down to the ones the scope purges
+func (o Options) scopedKinds(kinds []purgeKind) []purgeKind {
+	if o.purgesCluster() {
+		return kinds
+	}
+
+	var scoped []purgeKind
+	for _, kind := range kinds {
+		switch {
+		case o.Scope == ScopeCrds && kind.resource.GroupResource() == crdsKind.resource.GroupResource():
+			scoped = append(scoped, kind)
+		case o.Scope == ScopeNamespaces && kind.namespaced:
+			scoped = append(scoped, kind)
+		case o.Scope == ScopeNamespaces && kind.resource.GroupResource() == namespacesKind.resource.GroupResource() && !o.KeepNamespaces:
+			scoped = append(scoped, kind)
+		}
+	}
+	return scoped
+}
+
+// scopedNamespaces returns the namespaces the scope purges, reporting the ones in Namespaces that don't exist
+func (o Options) scopedNamespaces(namespaces []corev1.Namespace, errorCh chan<- error) []corev1.Namespace {
+	switch o.Scope {
+	case ScopeCrds:
+		return nil
+	case ScopeNamespaces:
+		byName := map[string]corev1.Namespace{}
+		for _, namespace := range namespaces {
+			byName[namespace.Name] = namespace
+		}
+
+		var scoped []corev1.Namespace
+		for _, name := range o.Namespaces {
+			namespace, ok := byName[name]
+			if !ok {
+				errorCh <- errors.New(fmt.Sprintf("namespace not found: %s", name))
+				continue
+			}
+			scoped = append(scoped, namespace)
+		}
+		return scoped
+	}
+	return namespaces
+}
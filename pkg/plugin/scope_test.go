@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"reflect"
+	"testing"
+)
+
+func TestScopedKinds(t *testing.T) {
+	// discovered kinds carry the preferred version and their plural as name
+	discoveredCrdsKind := purgeKind{"customresourcedefinitions", schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}, false}
+	discoveredNamespacesKind := purgeKind{"namespaces", namespacesKind.resource, false}
+	kinds := []purgeKind{configMapsKind, clusterRolesKind, namespacesKind, crdsKind, discoveredCrdsKind, discoveredNamespacesKind}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []purgeKind
+	}{
+		{name: "default scope", opts: Options{}, want: kinds},
+		{name: "cluster", opts: Options{Scope: ScopeCluster}, want: kinds},
+		{name: "namespaces", opts: Options{Scope: ScopeNamespaces}, want: []purgeKind{configMapsKind, namespacesKind, discoveredNamespacesKind}},
+		{name: "namespaces kept", opts: Options{Scope: ScopeNamespaces, KeepNamespaces: true}, want: []purgeKind{configMapsKind}},
+		{name: "crds", opts: Options{Scope: ScopeCrds}, want: []purgeKind{crdsKind, discoveredCrdsKind}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.opts.scopedKinds(kinds); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("scopedKinds() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScopedNamespaces(t *testing.T) {
+	namespace := func(name string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	namespaces := []corev1.Namespace{namespace("default"), namespace("dev"), namespace("staging")}
+
+	tests := []struct {
+		name       string
+		opts       Options
+		want       []string
+		wantErrors int
+	}{
+		{name: "cluster", opts: Options{Scope: ScopeCluster}, want: []string{"default", "dev", "staging"}},
+		{name: "crds", opts: Options{Scope: ScopeCrds}},
+		{name: "namespaces in the given order", opts: Options{Scope: ScopeNamespaces, Namespaces: []string{"staging", "dev"}}, want: []string{"staging", "dev"}},
+		{name: "missing namespace", opts: Options{Scope: ScopeNamespaces, Namespaces: []string{"dev", "prod"}}, want: []string{"dev"}, wantErrors: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errorCh := make(chan error, 10)
+			var got []string
+			for _, scoped := range test.opts.scopedNamespaces(namespaces, errorCh) {
+				got = append(got, scoped.Name)
+			}
+			close(errorCh)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("scopedNamespaces() = %v, want %v", got, test.want)
+			}
+			if len(errorCh) != test.wantErrors {
+				t.Errorf("expected %d errors, got %d", test.wantErrors, len(errorCh))
+			}
+		})
+	}
+}
+
+func TestSelectsCrd(t *testing.T) {
+	crd := apixv1.CustomResourceDefinition{Spec: apixv1.CustomResourceDefinitionSpec{Group: "cert-manager.io"}}
+	tests := []struct {
+		name string
+		opts Options
+		want bool
+	}{
+		{name: "cluster ignores groups", opts: Options{Scope: ScopeCluster, CrdGroups: []string{"example.com"}}, want: true},
+		{name: "crds without groups", opts: Options{Scope: ScopeCrds}, want: true},
+		{name: "crds of the group", opts: Options{Scope: ScopeCrds, CrdGroups: []string{"example.com", "cert-manager.io"}}, want: true},
+		{name: "crds of another group", opts: Options{Scope: ScopeCrds, CrdGroups: []string{"example.com"}}, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.opts.selectsCrd(crd); got != test.want {
+				t.Errorf("selectsCrd() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
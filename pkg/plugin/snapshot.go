@@ -0,0 +1,366 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+	"sync"
+	"time"
+)
+
+// kinds that belong to the cluster's infrastructure or history rather than to what was deployed, never purged back to a baseline
+var baselineIgnoredResources = []schema.GroupResource{
+	{Resource: "nodes"},
+	{Group: "storage.k8s.io", Resource: "csinodes"},
+	{Resource: "events"},
+	{Group: "events.k8s.io", Resource: "events"},
+}
+
+// Snapshot records the identity of every object on a cluster
+type Snapshot struct {
+	// UID of the kube-system namespace, a snapshot is only applied to the cluster it was taken of
+	ClusterUID types.UID        `json:"clusterUID"`
+	Created    metav1.Time      `json:"created"`
+	Objects    []SnapshotObject `json:"objects"`
+	// kinds that failed to be listed, as resource.group, their objects are never purged back to the snapshot
+	Unlisted []string `json:"unlisted,omitempty"`
+}
+
+// SnapshotObject is the identity of an object
+type SnapshotObject struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+}
+
+// baseline is the set of UIDs in a snapshot, objects are matched by UID so that recreated objects count as new
+type baseline struct {
+	uids map[types.UID]bool
+	// kinds missing from the snapshot, every object of them would count as new
+	unlisted []schema.GroupResource
+}
+
+func (b baseline) contains(object metav1.Object) bool {
+	return b.uids[object.GetUID()]
+}
+
+// SaveSnapshot writes the identity of every listable object on the cluster to out, as YAML.
+// A kind that fails to be listed is reported and recorded as unlisted.
+func SaveSnapshot(configFlags *genericclioptions.ConfigFlags, out io.Writer, logCh chan<- string, errorCh chan<- error) error {
+	defer close(logCh)
+	defer close(errorCh)
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	config, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read kubeconfig")
+	}
+	c, err := newClients(config)
+	if err != nil {
+		return err
+	}
+
+	kubeSystem, err := c.clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get kube-system namespace")
+	}
+	snapshot := Snapshot{
+		ClusterUID: kubeSystem.UID,
+		Created:    metav1.NewTime(time.Now()),
+	}
+
+	kinds, err := discoverKinds(c, "list")
+	if err != nil {
+		return err
+	}
+	for _, kind := range kinds {
+		list, err := c.dynamicClient.Resource(kind.resource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list %s, its objects won't be purged back to the snapshot", kind.name))
+			snapshot.Unlisted = append(snapshot.Unlisted, kind.resource.GroupResource().String())
+			continue
+		}
+		for _, object := range list.Items {
+			snapshot.Objects = append(snapshot.Objects, SnapshotObject{
+				APIVersion: object.GetAPIVersion(),
+				Kind:       object.GetKind(),
+				Namespace:  object.GetNamespace(),
+				Name:       object.GetName(),
+				UID:        object.GetUID(),
+			})
+		}
+	}
+	logCh <- fmt.Sprintf("Recorded %d objects", len(snapshot.Objects))
+
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode snapshot")
+	}
+	_, err = out.Write(data)
+	return errors.Wrap(err, "failed to write snapshot")
+}
+
+// loadBaseline reads the snapshot in file, and checks that it was taken of the cluster being purged
+func loadBaseline(ctx context.Context, c *clients, file string, guardrails Guardrails) (baseline, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return baseline{}, errors.Wrap(err, "failed to read baseline")
+	}
+	snapshot := Snapshot{}
+	if err := yaml.Unmarshal(data, &snapshot); err != nil {
+		return baseline{}, errors.Wrap(err, fmt.Sprintf("failed to decode baseline %s", file))
+	}
+
+	kubeSystem, err := c.clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return baseline{}, errors.Wrap(err, "failed to get kube-system namespace")
+	}
+	if kubeSystem.UID != snapshot.ClusterUID && !guardrails.Override {
+		return baseline{}, errors.New(fmt.Sprintf("baseline %s was taken of another cluster, pass --i-know-what-im-doing to use it anyway", file))
+	}
+
+	b := baseline{uids: map[types.UID]bool{}}
+	for _, object := range snapshot.Objects {
+		b.uids[object.UID] = true
+	}
+	for _, unlisted := range snapshot.Unlisted {
+		b.unlisted = append(b.unlisted, schema.ParseGroupResource(unlisted))
+	}
+	return b, nil
+}
+
+// checkBaseline rejects the options that purging to a baseline doesn't support
+func checkBaseline(opts Options) error {
+	if opts.Baseline == "" {
+		return nil
+	}
+	if opts.Converge || opts.Verify {
+		return errors.New("--to-baseline can't be combined with --converge or --verify")
+	}
+	if opts.purgesHelmReleases() {
+		return errors.New("--to-baseline can't be combined with --helm-release or --helm-releases-only")
+	}
+	return nil
+}
+
+// purgeToBaseline deletes every object in scope that isn't in the baseline, of every kind, keeping the system namespaces.
+// Namespaces that aren't in the baseline are purged as a whole, the others are purged of their new objects.
+func purgeToBaseline(ctx context.Context, c *clients, mapper meta.RESTMapper, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	kinds, err := discoverKinds(c, "list", "delete")
+	if err != nil {
+		return err
+	}
+	var scopedKinds []purgeKind
+	for _, kind := range opts.scopedKinds(kinds) {
+		if kind != namespacesKind && !kindIn(kind, baselineIgnoredResources) && !kindIn(kind, opts.baseline.unlisted) {
+			scopedKinds = append(scopedKinds, kind)
+		}
+	}
+
+	namespaces, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list namespaces")
+	}
+	var baselineNamespaces, otherNamespaces []corev1.Namespace
+	for _, namespace := range opts.scopedNamespaces(namespaces.Items, errorCh) {
+		if opts.baseline.contains(&namespace) {
+			baselineNamespaces = append(baselineNamespaces, namespace)
+		} else {
+			otherNamespaces = append(otherNamespaces, namespace)
+		}
+	}
+	keptNamespaces := selectNamespaces(ctx, c, baselineNamespaces, opts, logCh, errorCh)
+	newNamespaces := selectNamespaces(ctx, c, otherNamespaces, opts, logCh, errorCh)
+	selectedNamespaces := append(append([]string{}, keptNamespaces...), newNamespaces...)
+	if err := preflightAccess(ctx, c.clientset, append([]purgeKind{namespacesKind}, scopedKinds...), selectedNamespaces, &opts, logCh); err != nil {
+		return err
+	}
+
+	owners := newBaselineOwners()
+	waitGroup := sync.WaitGroup{}
+	for _, namespace := range newNamespaces {
+		namespace := namespace
+		logCh <- fmt.Sprintf("Purging namespace that isn't in the baseline: %s", namespace)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			purgeNamespaceWithStrategy(c, namespace, opts, logCh, errorCh)
+		}()
+	}
+
+	for _, kind := range scopedKinds {
+		targets := []string{""}
+		if kind.namespaced {
+			targets = keptNamespaces
+		}
+		for _, namespace := range targets {
+			kind, namespace := kind, namespace
+
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				deleteNewObjects(ctx, c, mapper, kind, namespace, opts, owners, logCh, errorCh)
+			}()
+		}
+	}
+	waitGroup.Wait()
+	return nil
+}
+
+// deleteNewObjects deletes the objects of kind in namespace that aren't in the baseline
+func deleteNewObjects(ctx context.Context, c *clients, mapper meta.RESTMapper, kind purgeKind, namespace string, opts Options, owners *baselineOwners, logCh chan<- string, errorCh chan<- error) {
+	if !opts.runsKind(kind, namespace) {
+		return
+	}
+
+	api := c.dynamicClient.Resource(kind.resource).Namespace(namespace)
+	list, err := api.List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		// the kind is gone, e.g. its CRD was deleted
+		return
+	}
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list %s in: %s", kind.name, namespace))
+		return
+	}
+
+	newObjects := &unstructured.UnstructuredList{}
+	for i := range list.Items {
+		object := &list.Items[i]
+		// with ScopeCrds, only the CRDs of CrdGroups
+		if !selectsCrdObject(kind, object, opts) {
+			continue
+		}
+		inBaseline, err := belongsToBaseline(ctx, c, mapper, object, opts, owners)
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get controller of %s %s, keeping it", kind.name, objectName(object)))
+			continue
+		}
+		if !inBaseline {
+			newObjects.Items = append(newObjects.Items, *object)
+		}
+	}
+
+	deleteFn := func(ctx context.Context, name string, deleteOptions metav1.DeleteOptions) error {
+		return api.Delete(ctx, name, deleteOptions)
+	}
+	deleteObjects(ctx, kind, namespace, newObjects, deleteFn, opts, logCh, errorCh)
+}
+
+// discoverKinds returns every kind that supports all of verbs
+func discoverKinds(c *clients, verbs ...string) ([]purgeKind, error) {
+	resourceLists, err := c.clientset.Discovery().ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "failed to discover resources")
+	}
+
+	var kinds []purgeKind
+	for _, resourceList := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: verbs}, resourceLists) {
+		groupVersion, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to parse group version %s", resourceList.GroupVersion))
+		}
+		for _, resource := range resourceList.APIResources {
+			// the kinds the run treats specially are matched by their built-in definition
+			kind := purgeKind{resource.Name, groupVersion.WithResource(resource.Name), resource.Namespaced}
+			switch kind.resource.GroupResource() {
+			case namespacesKind.resource.GroupResource():
+				kind = namespacesKind
+			case crdsKind.resource.GroupResource():
+				kind = crdsKind
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, nil
+}
+
+// baselineOwners caches whether controllers belong to the baseline, as many objects share the same one
+type baselineOwners struct {
+	mutex sync.Mutex
+	uids  map[types.UID]bool
+}
+
+func newBaselineOwners() *baselineOwners {
+	return &baselineOwners{uids: map[types.UID]bool{}}
+}
+
+func (o *baselineOwners) get(uid types.UID) (bool, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	inBaseline, ok := o.uids[uid]
+	return inBaseline, ok
+}
+
+func (o *baselineOwners) set(uids []types.UID, inBaseline bool) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, uid := range uids {
+		o.uids[uid] = inBaseline
+	}
+	return inBaseline
+}
+
+// belongsToBaseline reports whether object is in the baseline, or controlled by an object that is or that is kept,
+// directly or through its own controller. Restarts and rollouts give the ReplicaSets and pods of baseline workloads new UIDs.
+func belongsToBaseline(ctx context.Context, c *clients, mapper meta.RESTMapper, object metav1.Object, opts Options, owners *baselineOwners) (bool, error) {
+	var controllers []types.UID
+	current := object
+	for {
+		if opts.baseline.contains(current) {
+			return owners.set(controllers, true), nil
+		}
+		controller := metav1.GetControllerOf(current)
+		if controller == nil {
+			return owners.set(controllers, false), nil
+		}
+		if inBaseline, ok := owners.get(controller.UID); ok {
+			return owners.set(controllers, inBaseline), nil
+		}
+		for _, uid := range controllers {
+			// an ownership cycle, which the garbage collector can't resolve either
+			if uid == controller.UID {
+				return owners.set(controllers, false), nil
+			}
+		}
+		controllers = append(controllers, controller.UID)
+
+		owner, ownerKind, err := existingOwner(ctx, c, mapper, current.GetNamespace(), []metav1.OwnerReference{*controller})
+		if err != nil {
+			return false, err
+		}
+		if owner == nil {
+			return owners.set(controllers, false), nil
+		}
+		if opts.keepReason(ownerKind, owner, owner) != "" {
+			return owners.set(controllers, true), nil
+		}
+		current = owner
+	}
+}
+
+func kindIn(kind purgeKind, resources []schema.GroupResource) bool {
+	for _, resource := range resources {
+		if kind.resource.GroupResource() == resource {
+			return true
+		}
+	}
+	return false
+}
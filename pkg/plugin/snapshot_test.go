@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"golang.org/x/net/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"testing"
+)
+
+// testObject is an object in namespace dev, controlled by controller if it isn't nil
+func testObject(apiVersion string, kind string, name string, controller *unstructured.Unstructured) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion(apiVersion)
+	object.SetKind(kind)
+	object.SetNamespace("dev")
+	object.SetName(name)
+	object.SetUID(types.UID("uid-" + name))
+	if controller != nil {
+		isController := true
+		object.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: controller.GetAPIVersion(),
+			Kind:       controller.GetKind(),
+			Name:       controller.GetName(),
+			UID:        controller.GetUID(),
+			Controller: &isController,
+		}})
+	}
+	return object
+}
+
+func newBaselineClients(objects ...*unstructured.Unstructured) (*clients, meta.RESTMapper) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "Pod"},
+		{Version: "v1", Kind: "ConfigMap"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+		mapping, _ := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		listKinds[mapping.Resource] = gvk.Kind + "List"
+	}
+
+	runtimeObjects := make([]runtime.Object, 0, len(objects))
+	for _, object := range objects {
+		runtimeObjects = append(runtimeObjects, object)
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, runtimeObjects...)
+	return &clients{dynamicClient: dynamicClient}, mapper
+}
+
+func TestBelongsToBaseline(t *testing.T) {
+	// a baseline Deployment that was rolled out since the snapshot
+	web := testObject("apps/v1", "Deployment", "web", nil)
+	webReplicaSet := testObject("apps/v1", "ReplicaSet", "web-2", web)
+	webPod := testObject("v1", "Pod", "web-2-a", webReplicaSet)
+	// a Deployment created after the snapshot
+	api := testObject("apps/v1", "Deployment", "api", nil)
+	apiReplicaSet := testObject("apps/v1", "ReplicaSet", "api-1", api)
+	apiPod := testObject("v1", "Pod", "api-1-a", apiReplicaSet)
+	// a protected Deployment created after the snapshot is kept, and so is what it controls
+	pinned := testObject("apps/v1", "Deployment", "pinned", nil)
+	pinned.SetLabels(map[string]string{protectedLabel: "true"})
+	pinnedReplicaSet := testObject("apps/v1", "ReplicaSet", "pinned-1", pinned)
+	// the controller of an orphan is gone
+	gone := testObject("apps/v1", "ReplicaSet", "gone", nil)
+	orphan := testObject("v1", "Pod", "orphan", gone)
+	// objects controlling each other, which never reach the baseline
+	first := testObject("v1", "ConfigMap", "first", nil)
+	second := testObject("v1", "ConfigMap", "second", first)
+	first = testObject("v1", "ConfigMap", "first", second)
+
+	c, mapper := newBaselineClients(web, webReplicaSet, webPod, api, apiReplicaSet, apiPod, pinned, pinnedReplicaSet, orphan, first, second)
+	opts := Options{baseline: baseline{uids: map[types.UID]bool{web.GetUID(): true}}}
+
+	tests := []struct {
+		object *unstructured.Unstructured
+		want   bool
+	}{
+		{web, true},
+		{webReplicaSet, true},
+		{webPod, true},
+		{api, false},
+		{apiReplicaSet, false},
+		{apiPod, false},
+		{pinnedReplicaSet, true},
+		{orphan, false},
+		{first, false},
+	}
+	for _, test := range tests {
+		t.Run(test.object.GetName(), func(t *testing.T) {
+			got, err := belongsToBaseline(context.Background(), c, mapper, test.object, opts, newBaselineOwners())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("belongsToBaseline(%s) = %v, want %v", test.object.GetName(), got, test.want)
+			}
+		})
+	}
+}
+
+func TestBelongsToBaselineCachesControllers(t *testing.T) {
+	web := testObject("apps/v1", "Deployment", "web", nil)
+	webReplicaSet := testObject("apps/v1", "ReplicaSet", "web-2", web)
+	webPod := testObject("v1", "Pod", "web-2-a", webReplicaSet)
+	c, mapper := newBaselineClients(web, webReplicaSet, webPod)
+	opts := Options{baseline: baseline{uids: map[types.UID]bool{web.GetUID(): true}}}
+	owners := newBaselineOwners()
+
+	if _, err := belongsToBaseline(context.Background(), c, mapper, webPod, opts, owners); err != nil {
+		t.Fatal(err)
+	}
+	if inBaseline, ok := owners.get(webReplicaSet.GetUID()); !ok || !inBaseline {
+		t.Errorf("expected the ReplicaSet to be cached as in the baseline, got %v, %v", inBaseline, ok)
+	}
+}
+
+func TestDeleteNewObjects(t *testing.T) {
+	web := testObject("apps/v1", "Deployment", "web", nil)
+	webReplicaSet := testObject("apps/v1", "ReplicaSet", "web-2", web)
+	webPod := testObject("v1", "Pod", "web-2-a", webReplicaSet)
+	recorded := testObject("v1", "Pod", "recorded", nil)
+	created := testObject("v1", "Pod", "created", nil)
+	c, mapper := newBaselineClients(web, webReplicaSet, webPod, recorded, created)
+	opts := Options{baseline: baseline{uids: map[types.UID]bool{web.GetUID(): true, recorded.GetUID(): true}}}
+	logCh, errorCh, collect := collectLogs()
+
+	deleteNewObjects(context.Background(), c, mapper, podsKind, "dev", opts, newBaselineOwners(), logCh, errorCh)
+	_, errs := collect()
+
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	pods := c.dynamicClient.Resource(podsKind.resource).Namespace("dev")
+	for _, name := range []string{"web-2-a", "recorded"} {
+		if _, err := pods.Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+	if _, err := pods.Get(context.Background(), "created", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected created to be deleted, got %v", err)
+	}
+}
+
+func TestCheckBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "no baseline", opts: Options{Converge: true, Verify: true}},
+		{name: "baseline", opts: Options{Baseline: "clean.yaml"}},
+		{name: "with converge", opts: Options{Baseline: "clean.yaml", Converge: true}, wantErr: true},
+		{name: "with verify", opts: Options{Baseline: "clean.yaml", Verify: true}, wantErr: true},
+		{name: "with helm releases", opts: Options{Baseline: "clean.yaml", HelmReleasesOnly: true}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkBaseline(test.opts); (err != nil) != test.wantErr {
+				t.Errorf("checkBaseline() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+const (
+	// how long the retained volumes of deleted namespaces are waited for to be released
+	volumeReleaseTimeout = 2 * time.Minute
+	// how often the retained volumes are checked while waiting
+	volumeReleasePollInterval = 5 * time.Second
+)
+
+var (
+	retainPatch        = []byte(`{"spec":{"persistentVolumeReclaimPolicy":"Retain"}}`)
+	clearClaimRefPatch = []byte(`{"spec":{"claimRef":null}}`)
+)
+
+// keepsStorage reports whether kind is kept because of KeepStorage
+func (o Options) keepsStorage(kind purgeKind) bool {
+	if !o.KeepStorage {
+		return false
+	}
+	groupResource := kind.resource.GroupResource()
+	return groupResource == persistentVolumeClaimsKind.resource.GroupResource() ||
+		groupResource == persistentVolumesKind.resource.GroupResource()
+}
+
+// retainVolumes sets the reclaim policy of the volumes claimed in namespace to Retain,
+// so deleting the namespace and its claims doesn't delete their data
+func retainVolumes(ctx context.Context, c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.KeepStorage || !opts.RetainVolumes || opts.DryRun {
+		return
+	}
+	// the claims are only deleted along with their namespace
+	if !deletesNamespace(ctx, c, namespace, opts, logCh, errorCh) {
+		return
+	}
+
+	api := c.clientset.CoreV1().PersistentVolumes()
+	persistentVolumes, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list persistentVolumes")
+		return
+	}
+
+	for _, persistentVolume := range persistentVolumes.Items {
+		claimRef := persistentVolume.Spec.ClaimRef
+		if claimRef == nil || claimRef.Namespace != namespace || persistentVolume.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain {
+			continue
+		}
+
+		logCh <- fmt.Sprintf("Retaining persistentVolume %s of claim %s/%s", persistentVolume.Name, claimRef.Namespace, claimRef.Name)
+		if _, err := api.Patch(ctx, persistentVolume.Name, types.StrategicMergePatchType, retainPatch, metav1.PatchOptions{}); err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to retain persistentVolume %s", persistentVolume.Name))
+		}
+	}
+}
+
+// holdsStorage reports whether namespace must be kept, because deleting it would delete claims whose volumes aren't retained
+func holdsStorage(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) bool {
+	if !opts.KeepStorage || opts.RetainVolumes {
+		return false
+	}
+
+	claims, err := countClaims(ctx, clientset, namespace)
+	if err != nil {
+		errorCh <- err
+		return true
+	}
+	if claims > 0 {
+		logCh <- fmt.Sprintf("Keeping namespace %s, it holds %d persistentVolumeClaims, pass --retain-volumes to delete it anyway", namespace, claims)
+		return true
+	}
+	return false
+}
+
+func countClaims(ctx context.Context, clientset *kubernetes.Clientset, namespace string) (int, error) {
+	persistentVolumeClaims, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("failed to list persistentVolumeClaims in: %s", namespace))
+	}
+	return len(persistentVolumeClaims.Items), nil
+}
+
+// reportRetainedVolumes lists the Released volumes with the Retain policy that were left behind, and their capacity.
+// With RetainVolumes, the claimRef of the volumes claimed in namespaces is cleared first, so they can be bound again.
+func reportRetainedVolumes(ctx context.Context, c *clients, namespaces []string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if !opts.KeepStorage {
+		return
+	}
+
+	purgedNamespaces := map[string]bool{}
+	for _, namespace := range namespaces {
+		purgedNamespaces[namespace] = true
+	}
+	if opts.RetainVolumes && !opts.DryRun {
+		waitForReleasedVolumes(ctx, c, purgedNamespaces, logCh)
+	}
+
+	api := c.clientset.CoreV1().PersistentVolumes()
+	persistentVolumes, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, "failed to list persistentVolumes")
+		return
+	}
+
+	var released []string
+	capacity := resource.Quantity{}
+	for _, persistentVolume := range persistentVolumes.Items {
+		if persistentVolume.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+			continue
+		}
+
+		claimRef := persistentVolume.Spec.ClaimRef
+		if claimRef != nil && purgedNamespaces[claimRef.Namespace] && persistentVolume.Status.Phase == corev1.VolumeBound {
+			logCh <- fmt.Sprintf("PersistentVolume %s is still bound to %s/%s, it will be released once the claim is deleted", persistentVolume.Name, claimRef.Namespace, claimRef.Name)
+			continue
+		}
+		if persistentVolume.Status.Phase != corev1.VolumeReleased {
+			continue
+		}
+
+		if opts.RetainVolumes && !opts.DryRun && claimRef != nil && purgedNamespaces[claimRef.Namespace] {
+			if _, err := api.Patch(ctx, persistentVolume.Name, types.MergePatchType, clearClaimRefPatch, metav1.PatchOptions{}); err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to clear claimRef of persistentVolume %s", persistentVolume.Name))
+			} else {
+				logCh <- fmt.Sprintf("PersistentVolume %s is available to be bound again", persistentVolume.Name)
+				continue
+			}
+		}
+
+		storage := persistentVolume.Spec.Capacity[corev1.ResourceStorage]
+		capacity.Add(storage)
+		released = append(released, fmt.Sprintf("%s (%s)", persistentVolume.Name, storage.String()))
+	}
+
+	if len(released) > 0 {
+		logCh <- fmt.Sprintf("Left behind %d Released persistentVolumes with the Retain policy, holding %s: %s", len(released), capacity.String(), strings.Join(released, ", "))
+	}
+}
+
+// waitForReleasedVolumes waits up to volumeReleaseTimeout for the retained volumes claimed in namespaces that are being
+// deleted to be Released. Namespaces are deleted asynchronously, so their claims may still exist at the end of the run.
+func waitForReleasedVolumes(ctx context.Context, c *clients, namespaces map[string]bool, logCh chan<- string) {
+	// the namespaces that are kept keep their claims, there is nothing to wait for
+	deleted := map[string]bool{}
+	for namespace := range namespaces {
+		if namespaceDeleted(ctx, c, namespace) {
+			deleted[namespace] = true
+		}
+	}
+	if len(deleted) == 0 {
+		return
+	}
+
+	lastBound := -1
+	err := wait.PollImmediate(volumeReleasePollInterval, volumeReleaseTimeout, func() (bool, error) {
+		persistentVolumes, err := c.clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+		bound := 0
+		for _, persistentVolume := range persistentVolumes.Items {
+			claimRef := persistentVolume.Spec.ClaimRef
+			if persistentVolume.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain &&
+				claimRef != nil && deleted[claimRef.Namespace] && persistentVolume.Status.Phase == corev1.VolumeBound {
+				bound++
+			}
+		}
+		if bound > 0 && bound != lastBound {
+			logCh <- verbose(fmt.Sprintf("Waiting for the claims of %d retained persistentVolumes to be deleted", bound))
+		}
+		lastBound = bound
+		return bound == 0, nil
+	})
+	if err != nil {
+		logCh <- fmt.Sprintf("Some retained persistentVolumes are still bound after %s, they can be bound again once released and their claimRef is cleared", volumeReleaseTimeout)
+	}
+}
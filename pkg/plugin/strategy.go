@@ -0,0 +1,271 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/robertsmieja/kubectl-purge/pkg/util"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"strings"
+	"time"
+)
+
+const (
+	// delete the contents of a namespace kind by kind, then the namespace
+	StrategyContentsFirst = "contents-first"
+	// delete the namespace straight away and leave its contents to the namespace controller
+	StrategyNamespaceDelete = "namespace-delete"
+	// delete the custom resources and stop the workloads, then leave the rest to the namespace controller
+	StrategyHybrid = "hybrid"
+)
+
+// how often a deleted namespace is checked while the namespace controller empties it
+const namespacePollInterval = 2 * time.Second
+
+// StrategyNames returns the valid values of Options.Strategy
+func StrategyNames() []string {
+	return []string{StrategyContentsFirst, StrategyNamespaceDelete, StrategyHybrid}
+}
+
+func checkStrategy(strategy string) error {
+	if strategy == "" || util.Contains(StrategyNames(), strategy) {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown strategy %s, use one of: %s", strategy, strings.Join(StrategyNames(), ", ")))
+}
+
+// purgeNamespaceWithStrategy purges namespace the way the Strategy says, between its pre and post hooks
+func purgeNamespaceWithStrategy(c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	// the annotations are read before the namespace may be gone
+	hooks, err := opts.hooks.namespaceHooks(ctx, c, namespace)
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("skipping namespace %s", namespace))
+		return
+	}
+	if err := opts.hooks.runHooks(ctx, c, namespace, hooks, HookPhasePre, opts, logCh, errorCh); err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("skipping namespace %s", namespace))
+		return
+	}
+
+	// the cloud controller cleans up load balancers asynchronously, so it gets the rest of the run to do so
+	opts.loadBalancers.add(deleteLoadBalancers(ctx, c.clientset, namespace, opts, logCh, errorCh))
+
+	purgeWithStrategy(ctx, c, namespace, opts, logCh, errorCh)
+
+	// jobs can't run in a namespace that is being deleted
+	var postHooks []Hook
+	for _, hook := range hooks {
+		if hook.Phase == HookPhasePost && hook.Job != nil && namespaceDeleted(ctx, c, namespace) {
+			logCh <- verbose(fmt.Sprintf("Skipping %s, namespace %s is deleted", hook, namespace))
+			continue
+		}
+		postHooks = append(postHooks, hook)
+	}
+	if err := opts.hooks.runHooks(ctx, c, namespace, postHooks, HookPhasePost, opts, logCh, errorCh); err != nil {
+		errorCh <- err
+	}
+}
+
+// namespaceDeleted reports whether namespace is gone or terminating
+func namespaceDeleted(ctx context.Context, c *clients, namespace string) bool {
+	current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return apierrors.IsNotFound(err)
+	}
+	return current.DeletionTimestamp != nil
+}
+
+// purgeWithStrategy purges namespace the way the Strategy says. Namespaces that are kept,
+// e.g. "default", and dry runs are always purged contents first.
+func purgeWithStrategy(ctx context.Context, c *clients, namespace string, opts Options, logCh chan<- string, errorCh chan<- error) {
+	if opts.Strategy == "" || opts.Strategy == StrategyContentsFirst || opts.DryRun {
+		purgeNamespace(c, namespace, opts, logCh, errorCh)
+		return
+	}
+
+	if !deletesNamespace(ctx, c, namespace, opts, logCh, errorCh) {
+		// already known to be kept, without checking again
+		keptOpts := opts
+		keptOpts.KeepNamespaces = true
+		purgeNamespace(c, namespace, keptOpts, logCh, errorCh)
+		return
+	}
+
+	// before the claims are deleted along with the namespace
+	retainVolumes(ctx, c, namespace, opts, logCh, errorCh)
+
+	if opts.Strategy == StrategyHybrid {
+		// operators may still have to finalize their custom resources, which they can't once the namespace controller deleted them
+		deleteNamespacedCustomResources(c.apixClient, c.dynamicClient, namespace, opts, logCh, errorCh)
+		drainNamespace(ctx, c.clientset, namespace, opts, logCh, errorCh)
+	}
+
+	current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get namespace: %s", namespace))
+		return
+	}
+	if !deleteNamespace(ctx, c, namespace, opts, logCh, errorCh) {
+		return
+	}
+	if namespaceGone(ctx, c, namespace, current.UID, opts, errorCh) {
+		return
+	}
+
+	// fall back to deleting what blocks the namespace controller ourselves, the namespace is already being deleted
+	logCh <- fmt.Sprintf("Namespace %s is still terminating after %s, purging its contents%s", namespace, opts.NamespaceTimeout, namespaceBlockers(ctx, c, namespace))
+	fallbackOpts := opts
+	fallbackOpts.KeepNamespaces = true
+	purgeNamespace(c, namespace, fallbackOpts, logCh, errorCh)
+
+	// the namespace controller already deleted everything it could, what is left waits for finalizers whose controllers may be gone
+	removeFinalizers(ctx, c, namespace, logCh, errorCh)
+	if namespaceGone(ctx, c, namespace, current.UID, opts, errorCh) {
+		return
+	}
+	finalizeNamespace(ctx, c, namespace, current.UID, logCh, errorCh)
+}
+
+// removeFinalizers removes the finalizers of the objects in namespace that are being deleted, except the load balancer
+// cleanup finalizer, without which the cloud load balancer would leak
+func removeFinalizers(ctx context.Context, c *clients, namespace string, logCh chan<- string, errorCh chan<- error) {
+	kinds, err := discoverNamespacedKinds(c)
+	if err != nil {
+		errorCh <- err
+		return
+	}
+
+	cleared := 0
+	for _, kind := range kinds {
+		// events have no finalizers, and are too many to list
+		if kind.resource.Resource == eventsKind.resource.Resource {
+			continue
+		}
+
+		api := c.dynamicClient.Resource(kind.resource).Namespace(namespace)
+		list, err := api.List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to list %s in: %s", kind.name, namespace))
+			continue
+		}
+
+		for i := range list.Items {
+			object := &list.Items[i]
+			if object.GetDeletionTimestamp() == nil {
+				continue
+			}
+
+			var kept, removed []string
+			for _, finalizer := range object.GetFinalizers() {
+				if finalizer == loadBalancerCleanupFinalizer {
+					kept = append(kept, finalizer)
+				} else {
+					removed = append(removed, finalizer)
+				}
+			}
+			if len(removed) == 0 {
+				continue
+			}
+
+			patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"finalizers": kept}})
+			if err != nil {
+				errorCh <- errors.Wrap(err, "failed to encode finalizers")
+				return
+			}
+			_, err = api.Patch(ctx, object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				errorCh <- errors.Wrap(err, fmt.Sprintf("failed to remove finalizers of %s %s", kind.name, objectName(object)))
+				continue
+			}
+			logCh <- fmt.Sprintf("Removed finalizers of terminating %s %s: %s", kind.name, objectName(object), strings.Join(removed, ", "))
+			cleared++
+		}
+	}
+	logCh <- fmt.Sprintf("Removed the finalizers of %d terminating objects in namespace: %s", cleared, namespace)
+}
+
+// finalizeNamespace removes the finalizers of namespace itself, so it is deleted although the namespace controller couldn't
+// finish emptying it. Objects that are still in it are left behind in storage.
+func finalizeNamespace(ctx context.Context, c *clients, namespace string, uid types.UID, logCh chan<- string, errorCh chan<- error) {
+	current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get namespace: %s", namespace))
+		return
+	}
+	// recreated under the same name
+	if current.UID != uid || len(current.Spec.Finalizers) == 0 {
+		return
+	}
+
+	finalizers := make([]string, 0, len(current.Spec.Finalizers))
+	for _, finalizer := range current.Spec.Finalizers {
+		finalizers = append(finalizers, string(finalizer))
+	}
+	blockers := namespaceBlockers(ctx, c, namespace)
+	current.Spec.Finalizers = nil
+	if _, err := c.clientset.CoreV1().Namespaces().Finalize(ctx, current, metav1.UpdateOptions{}); err != nil {
+		errorCh <- errors.Wrap(err, fmt.Sprintf("failed to finalize namespace: %s", namespace))
+		return
+	}
+	logCh <- fmt.Sprintf("Removed the finalizers of namespace %s, which was still terminating%s: %s", namespace, blockers, strings.Join(finalizers, ", "))
+}
+
+// namespaceGone waits up to NamespaceTimeout for the namespace controller to finish deleting namespace
+func namespaceGone(ctx context.Context, c *clients, namespace string, uid types.UID, opts Options, errorCh chan<- error) bool {
+	gone := false
+	_ = wait.PollImmediate(namespacePollInterval, opts.NamespaceTimeout, func() (bool, error) {
+		current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			gone = true
+			return true, nil
+		}
+		if err != nil {
+			errorCh <- errors.Wrap(err, fmt.Sprintf("failed to get namespace: %s", namespace))
+			return false, nil
+		}
+		// recreated under the same name
+		gone = current.UID != uid
+		return gone, nil
+	})
+	return gone
+}
+
+// namespaceBlockers explains why the namespace controller hasn't finished, from the conditions of the namespace
+func namespaceBlockers(ctx context.Context, c *clients, namespace string) string {
+	current, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+
+	var blockers []string
+	for _, condition := range current.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case corev1.NamespaceContentRemaining, corev1.NamespaceFinalizersRemaining, corev1.NamespaceDeletionDiscoveryFailure, corev1.NamespaceDeletionContentFailure, corev1.NamespaceDeletionGVParsingFailure:
+			blockers = append(blockers, condition.Message)
+		}
+	}
+	if len(blockers) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(blockers, "; ")
+}
@@ -0,0 +1,365 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// how often the leftovers are inventoried again while objects are still terminating
+const verifyPollInterval = 5 * time.Second
+
+// deletedTracker records the UIDs of the objects deleted during a run, so that recreated objects can be told apart
+type deletedTracker struct {
+	mutex sync.Mutex
+	uids  map[string]types.UID
+	// every deleted UID, to look up owners by
+	deleted map[types.UID]bool
+}
+
+func newDeletedTracker() *deletedTracker {
+	return &deletedTracker{uids: map[string]types.UID{}, deleted: map[types.UID]bool{}}
+}
+
+func deletedKey(kind purgeKind, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind.resource.GroupResource(), namespace, name)
+}
+
+func (t *deletedTracker) add(kind purgeKind, object metav1.Object) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.uids[deletedKey(kind, object.GetNamespace(), object.GetName())] = object.GetUID()
+	t.deleted[object.GetUID()] = true
+}
+
+// deletesAnyOwner reports whether one of owners was deleted during the run
+func (t *deletedTracker) deletesAnyOwner(owners []metav1.OwnerReference) bool {
+	if t == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, owner := range owners {
+		if t.deleted[owner.UID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *deletedTracker) uid(kind purgeKind, namespace string, name string) (types.UID, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	uid, ok := t.uids[deletedKey(kind, namespace, name)]
+	return uid, ok
+}
+
+// residual is an object that is left after a purge, though it should have been deleted
+type residual struct {
+	kind      purgeKind
+	namespace string
+	name      string
+	reason    string
+	// the object is being deleted, and may still disappear
+	terminating bool
+}
+
+// residuals is a table of the residual objects and their reasons
+type residuals []residual
+
+func (r residuals) String() string {
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].namespace != r[j].namespace {
+			return r[i].namespace < r[j].namespace
+		}
+		if r[i].kind.name != r[j].kind.name {
+			return r[i].kind.name < r[j].kind.name
+		}
+		return r[i].name < r[j].name
+	})
+
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tNAMESPACE\tNAME\tREASON")
+	for _, residual := range r {
+		namespace := residual.namespace
+		if !residual.kind.namespaced {
+			namespace = "(cluster)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", residual.kind.name, namespace, residual.name, residual.reason)
+	}
+	_ = writer.Flush()
+	return strings.TrimRight(buffer.String(), "\n")
+}
+
+func (r residuals) terminating() bool {
+	for _, residual := range r {
+		if residual.terminating {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPlugin inventories what a purge with these options leaves behind, and returns an error if anything unexpected remains
+func VerifyPlugin(configFlags *genericclioptions.ConfigFlags, opts Options, logCh chan<- string, errorCh chan<- error) error {
+	defer close(logCh)
+	defer close(errorCh)
+
+	ctx, cancel := createCtx()
+	defer cancel()
+
+	config, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read kubeconfig")
+	}
+	c, err := newClients(config)
+	if err != nil {
+		return err
+	}
+
+	opts.profile, err = resolveProfile(ctx, c.clientset, opts.Profile, logCh)
+	if err != nil {
+		return err
+	}
+	opts.kindFilter, err = resolveKindFilter(configFlags, opts)
+	if err != nil {
+		return err
+	}
+	mapper, err := configFlags.ToRESTMapper()
+	if err != nil {
+		return errors.Wrap(err, "failed to create REST mapper")
+	}
+
+	namespaces, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list namespaces")
+	}
+	selectedNamespaces := selectNamespaces(ctx, c, opts.scopedNamespaces(namespaces.Items, errorCh), opts, logCh, errorCh)
+
+	return verifyPurge(ctx, c, mapper, selectedNamespaces, opts, logCh)
+}
+
+// verifyPurge inventories the leftovers of a purge of namespaces, waiting up to VerifyTimeout for terminating objects to disappear
+func verifyPurge(ctx context.Context, c *clients, mapper meta.RESTMapper, namespaces []string, opts Options, logCh chan<- string) error {
+	logCh <- "Verifying the purge"
+
+	var leftovers residuals
+	var inventoryErr error
+	_ = wait.PollImmediate(verifyPollInterval, opts.VerifyTimeout, func() (bool, error) {
+		leftovers, inventoryErr = inventoryResiduals(ctx, c, mapper, namespaces, opts)
+		if inventoryErr != nil {
+			return false, inventoryErr
+		}
+		return !leftovers.terminating(), nil
+	})
+	if inventoryErr != nil {
+		return inventoryErr
+	}
+
+	if len(leftovers) == 0 {
+		logCh <- "Verified, nothing unexpected remains"
+		return nil
+	}
+	return errors.New(fmt.Sprintf("%d objects remain after the purge\n%s", len(leftovers), leftovers))
+}
+
+// inventoryResiduals lists the objects that remain although the purge should have deleted them
+func inventoryResiduals(ctx context.Context, c *clients, mapper meta.RESTMapper, namespaces []string, opts Options) (residuals, error) {
+	var found residuals
+
+	purged := map[schema.GroupResource]purgeKind{}
+	for _, kind := range planKinds(ctx, c, opts) {
+		// new events keep being recorded, also about the purge itself
+		if kind == eventsKind {
+			continue
+		}
+		purged[kind.resource.GroupResource()] = kind
+	}
+
+	namespacedKinds, err := discoverNamespacedKinds(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, namespace := range namespaces {
+		deletesNamespace := namespace != metav1.NamespaceDefault && !opts.KeepNamespaces && opts.runsKind(namespacesKind, "")
+		if deletesNamespace && opts.KeepStorage && !opts.RetainVolumes {
+			claims, err := countClaims(ctx, c.clientset, namespace)
+			if err != nil {
+				return nil, err
+			}
+			deletesNamespace = claims == 0
+		}
+		if deletesNamespace {
+			holdsKept, err := holdsObjectsOutsideAgeFilter(ctx, c, namespace, opts)
+			if err != nil {
+				return nil, err
+			}
+			deletesNamespace = !holdsKept
+		}
+
+		if deletesNamespace {
+			object, err := c.dynamicClient.Resource(namespacesKind.resource).Get(ctx, namespace, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to get namespace: %s", namespace))
+			}
+
+			reason, terminating := residualReason(ctx, c, mapper, namespacesKind, object, opts)
+			found = append(found, residual{namespacesKind, "", namespace, reason, terminating})
+			// the contents of a terminating namespace are the namespace controller's business
+			if terminating {
+				continue
+			}
+		}
+
+		for _, kind := range namespacedKinds {
+			// in kept namespaces, only the kinds the run purges have to be gone
+			purgedKind, ok := purged[kind.resource.GroupResource()]
+			if kind.resource.Resource == eventsKind.resource.Resource {
+				continue
+			}
+			if ok {
+				kind = purgedKind
+			}
+			if !deletesNamespace && (!ok || !opts.runsKind(kind, namespace)) {
+				continue
+			}
+
+			kindResiduals, err := inventoryKind(ctx, c, mapper, kind, namespace, opts)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, kindResiduals...)
+		}
+	}
+
+	for _, kind := range purged {
+		if kind.namespaced || kind == namespacesKind || !opts.runsKind(kind, "") {
+			continue
+		}
+		kindResiduals, err := inventoryKind(ctx, c, mapper, kind, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, kindResiduals...)
+	}
+	return found, nil
+}
+
+// inventoryKind lists the residual objects of kind in namespace
+func inventoryKind(ctx context.Context, c *clients, mapper meta.RESTMapper, kind purgeKind, namespace string, opts Options) (residuals, error) {
+	list, err := c.dynamicClient.Resource(kind.resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		// the kind is gone, e.g. its CRD was deleted
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to list %s in: %s", kind.name, namespace))
+	}
+
+	var found residuals
+	for i := range list.Items {
+		object := &list.Items[i]
+		if opts.keepReason(kind, object, object) != "" || !selectsCrdObject(kind, object, opts) {
+			continue
+		}
+
+		reason, terminating := residualReason(ctx, c, mapper, kind, object, opts)
+		if reason == "" {
+			continue
+		}
+		found = append(found, residual{kind, object.GetNamespace(), object.GetName(), reason, terminating})
+	}
+	return found, nil
+}
+
+// selectsCrdObject reports whether a CRD is purged by the run, objects of other kinds always are
+func selectsCrdObject(kind purgeKind, object *unstructured.Unstructured, opts Options) bool {
+	if kind != crdsKind {
+		return true
+	}
+	crd := apixv1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &crd); err != nil {
+		return true
+	}
+	return opts.selectsCrd(crd) && opts.runsKind(customResourceKind(crd), "")
+}
+
+// residualReason explains why object remains, "" if it is kept on purpose
+func residualReason(ctx context.Context, c *clients, mapper meta.RESTMapper, kind purgeKind, object *unstructured.Unstructured, opts Options) (reason string, terminating bool) {
+	if object.GetDeletionTimestamp() != nil {
+		if finalizers := object.GetFinalizers(); len(finalizers) > 0 {
+			return fmt.Sprintf("terminating, waiting for finalizers: %s", strings.Join(finalizers, ", ")), true
+		}
+		return "terminating", true
+	}
+
+	if len(object.GetOwnerReferences()) > 0 {
+		owner, ownerKind, err := existingOwner(ctx, c, mapper, object.GetNamespace(), object.GetOwnerReferences())
+		if err != nil {
+			return fmt.Sprintf("failed to get owner: %s", err), false
+		}
+		if owner != nil {
+			// dependents of kept objects are kept with them
+			if opts.keepReason(ownerKind, owner, owner) != "" || !opts.runsKind(ownerKind, owner.GetNamespace()) {
+				return "", false
+			}
+			return fmt.Sprintf("owner %s %s still exists", owner.GetKind(), owner.GetName()), false
+		}
+	}
+
+	if uid, ok := opts.deleted.uid(kind, object.GetNamespace(), object.GetName()); ok && uid != object.GetUID() {
+		return "recreated, new UID", false
+	}
+	if opts.deleted != nil {
+		return "not deleted, see the errors above", false
+	}
+	return "not deleted", false
+}
+
+// discoverNamespacedKinds returns every namespaced kind that can be listed
+func discoverNamespacedKinds(c *clients) ([]purgeKind, error) {
+	resourceLists, err := c.clientset.Discovery().ServerPreferredNamespacedResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "failed to discover namespaced resources")
+	}
+
+	var kinds []purgeKind
+	for _, resourceList := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, resourceLists) {
+		groupVersion, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to parse group version %s", resourceList.GroupVersion))
+		}
+		for _, resource := range resourceList.APIResources {
+			kinds = append(kinds, purgeKind{resource.Name, groupVersion.WithResource(resource.Name), true})
+		}
+	}
+	return kinds, nil
+}
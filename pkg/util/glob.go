@@ -0,0 +1,24 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MatchesAnyGlob reports whether str matches any of the glob patterns.
+// Unlike path.Match, '*' also matches '/', so patterns can be used against URLs.
+func MatchesAnyGlob(patterns []string, str string) bool {
+	for _, pattern := range patterns {
+		if globToRegexp(pattern).MatchString(str) {
+			return true
+		}
+	}
+	return false
+}
+
+func globToRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
@@ -0,0 +1,34 @@
+package util
+
+import "testing"
+
+func TestMatchesAnyGlob(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		str      string
+		want     bool
+	}{
+		{"exact", []string{"prod"}, "prod", true},
+		{"no partial match", []string{"prod"}, "prod-eu", false},
+		{"star suffix", []string{"prod-*"}, "prod-eu", true},
+		{"star matches nothing", []string{"prod-*"}, "prod-", true},
+		{"star prefix", []string{"*-prod"}, "eu-prod", true},
+		{"star matches slashes", []string{"*.prod.example.com*"}, "https://api.prod.example.com:6443/path", true},
+		{"question mark matches one character", []string{"node-?"}, "node-1", true},
+		{"question mark doesn't match two", []string{"node-?"}, "node-12", false},
+		{"dots are literal", []string{"a.b"}, "axb", false},
+		{"regexp characters are literal", []string{"(prod)+"}, "(prod)+", true},
+		{"any of several", []string{"dev", "staging-*"}, "staging-2", true},
+		{"none of several", []string{"dev", "staging-*"}, "prod", false},
+		{"no patterns", nil, "prod", false},
+		{"empty string", []string{"*"}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MatchesAnyGlob(test.patterns, test.str); got != test.want {
+				t.Errorf("MatchesAnyGlob(%q, %q) = %v, want %v", test.patterns, test.str, got, test.want)
+			}
+		})
+	}
+}